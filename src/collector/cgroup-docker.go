@@ -0,0 +1,296 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Container IDs as they appear in the cgroup paths created by docker.
+var dockerIdRegex = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// Delay before asking docker again about an ID it failed to answer for.
+const dockerRetryDelay = time.Minute
+
+type DockerContainer struct {
+	ID     string
+	Name   string // Container name, without the leading slash
+	Image  string
+	Labels map[string]string
+}
+
+// dockerClient resolves container IDs through the Docker Engine API served on
+// a unix socket. Containers are cached, the cache being filled from the
+// container list and kept up to date by following the container events. The
+// IDs missing from the cache are looked up in the background, so a slow or
+// missing daemon never holds up a collection.
+type dockerClient struct {
+	client     *http.Client // Requests with a timeout
+	stream     *http.Client // Requests kept open, such as events
+	mutex      sync.RWMutex
+	containers map[string]*DockerContainer // Nil for the IDs docker doesn't know
+	pending    map[string]bool             // IDs queued for a lookup
+	retries    map[string]time.Time        // IDs whose lookup failed, by time of the next attempt
+	available  bool                        // Whether the last refresh succeeded
+	lookups    chan string
+	done       chan struct{}
+}
+
+func newDockerClient(socket string) *dockerClient {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+
+	return &dockerClient{
+		client:     &http.Client{Transport: transport, Timeout: 5 * time.Second},
+		stream:     &http.Client{Transport: transport},
+		containers: make(map[string]*DockerContainer),
+		pending:    make(map[string]bool),
+		retries:    make(map[string]time.Time),
+		lookups:    make(chan string, 64),
+		done:       make(chan struct{}),
+	}
+}
+
+// get decodes the JSON response of an API request. The host of the URL is
+// ignored, requests go to the socket.
+func (d *dockerClient) get(path string, v interface{}) error {
+	resp, err := d.client.Get("http://docker" + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker %s: %s", path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// refresh replaces the cache with the running containers. Until it succeeds
+// again after a failure no lookup is made.
+func (d *dockerClient) refresh() error {
+	var list []struct {
+		Id     string
+		Names  []string
+		Image  string
+		Labels map[string]string
+	}
+
+	if err := d.get("/containers/json", &list); err != nil {
+		d.mutex.Lock()
+		d.available = false
+		d.mutex.Unlock()
+		return err
+	}
+
+	containers := make(map[string]*DockerContainer)
+	for _, c := range list {
+		container := &DockerContainer{ID: c.Id, Image: c.Image, Labels: c.Labels}
+		if len(c.Names) > 0 {
+			container.Name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		containers[c.Id] = container
+	}
+
+	d.mutex.Lock()
+	d.containers = containers
+	d.retries = make(map[string]time.Time)
+	d.available = true
+	d.mutex.Unlock()
+
+	return nil
+}
+
+// inspect caches a single container, or its absence when docker doesn't
+// know the ID.
+func (d *dockerClient) inspect(id string) (*DockerContainer, error) {
+	var c struct {
+		Id     string
+		Name   string
+		Config struct {
+			Image  string
+			Labels map[string]string
+		}
+	}
+
+	resp, err := d.client.Get("http://docker/containers/" + id + "/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var container *DockerContainer
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+			return nil, err
+		}
+		container = &DockerContainer{
+			ID:     c.Id,
+			Name:   strings.TrimPrefix(c.Name, "/"),
+			Image:  c.Config.Image,
+			Labels: c.Config.Labels,
+		}
+	case http.StatusNotFound:
+	default:
+		return nil, fmt.Errorf("docker inspect %s: %s", id, resp.Status)
+	}
+
+	d.mutex.Lock()
+	d.containers[id] = container
+	d.mutex.Unlock()
+
+	return container, nil
+}
+
+// container returns the cached container of an ID. An ID missing from the
+// cache is queued for a lookup, unless docker is unavailable or failed to
+// answer for it less than dockerRetryDelay ago, and reported unknown.
+func (d *dockerClient) container(id string) (*DockerContainer, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if container, found := d.containers[id]; found {
+		return container, container != nil
+	}
+
+	if !d.available || d.pending[id] || time.Now().Before(d.retries[id]) {
+		return nil, false
+	}
+
+	select {
+	case d.lookups <- id:
+		d.pending[id] = true
+	default:
+	}
+
+	return nil, false
+}
+
+// resolve looks up the queued IDs until close is called.
+func (d *dockerClient) resolve() {
+	for {
+		select {
+		case <-d.done:
+			return
+		case id := <-d.lookups:
+			_, err := d.inspect(id)
+
+			d.mutex.Lock()
+			delete(d.pending, id)
+			if err != nil {
+				d.retries[id] = time.Now().Add(dockerRetryDelay)
+			}
+			d.mutex.Unlock()
+
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
+
+// watch follows the container events until close is called, refreshing the
+// cache each time it reconnects as events may have been missed. The cache is
+// expected to have been filled by refresh first.
+func (d *dockerClient) watch() {
+	filters := url.QueryEscape(`{"type":["container"]}`)
+
+	for {
+		if err := d.follow("/events?filters=" + filters); err != nil {
+			fmt.Println(err)
+		}
+
+		select {
+		case <-d.done:
+			return
+		case <-time.After(5 * time.Second):
+		}
+
+		if err := d.refresh(); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// follow applies the events of the stream to the cache until it ends.
+func (d *dockerClient) follow(path string) error {
+	req, err := http.NewRequest("GET", "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-d.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	resp, err := d.stream.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker %s: %s", path, resp.Status)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event struct {
+			Action string
+			Actor  struct {
+				ID string
+			}
+		}
+
+		if err := decoder.Decode(&event); err != nil {
+			return err
+		}
+
+		switch event.Action {
+		case "create", "start", "rename", "update":
+			if _, err := d.inspect(event.Actor.ID); err != nil {
+				fmt.Println(err)
+			}
+		case "destroy":
+			d.mutex.Lock()
+			delete(d.containers, event.Actor.ID)
+			d.mutex.Unlock()
+		}
+	}
+}
+
+func (d *dockerClient) close() {
+	close(d.done)
+}
+
+// dockerName renders the metric name of a container with a template.
+func dockerName(tmpl *template.Template, container *DockerContainer) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, container); err != nil {
+		return "", err
+	}
+	return metricPath(buf.String()), nil
+}
@@ -0,0 +1,229 @@
+package collector
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+var (
+	dockerWeb     = strings.Repeat("a", 64)
+	dockerDb      = strings.Repeat("b", 64)
+	dockerUnknown = strings.Repeat("c", 64)
+)
+
+// fakeDocker serves the Docker Engine API endpoints the client uses on a
+// unix socket. Events written to events are streamed to /events.
+type fakeDocker struct {
+	socket   string
+	events   chan string
+	mutex    sync.Mutex
+	inspects map[string]int
+	down     bool // Whether /containers/json fails
+}
+
+func newFakeDocker(t *testing.T) *fakeDocker {
+	fd := &fakeDocker{
+		socket:   filepath.Join(t.TempDir(), "docker.sock"),
+		events:   make(chan string),
+		inspects: make(map[string]int),
+	}
+
+	listener, err := net.Listen("unix", fd.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		fd.mutex.Lock()
+		down := fd.down
+		fd.mutex.Unlock()
+
+		if down {
+			http.Error(w, "daemon unavailable", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, `[{"Id":"%s","Names":["/web"],"Image":"nginx:1.25","Labels":{"svc":"front"}}]`, dockerWeb)
+	})
+
+	mux.HandleFunc("/containers/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/containers/"), "/json")
+
+		fd.mutex.Lock()
+		fd.inspects[id]++
+		fd.mutex.Unlock()
+
+		if id != dockerDb {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"Id":"%s","Name":"/db","Config":{"Image":"postgres","Labels":{"svc":"back"}}}`, dockerDb)
+	})
+
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case event := <-fd.events:
+				fmt.Fprintln(w, event)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+
+	server := httptest.NewUnstartedServer(mux)
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return fd
+}
+
+func (fd *fakeDocker) inspected(id string) int {
+	fd.mutex.Lock()
+	defer fd.mutex.Unlock()
+	return fd.inspects[id]
+}
+
+// cached returns the cache entry of an ID, a nil container when docker
+// doesn't know the ID.
+func cached(d *dockerClient, id string) (*DockerContainer, bool) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	container, found := d.containers[id]
+	return container, found
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestDockerRefresh(t *testing.T) {
+	fd := newFakeDocker(t)
+	d := newDockerClient(fd.socket)
+	defer d.close()
+
+	if err := d.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	container, ok := d.container(dockerWeb)
+	if !ok {
+		t.Fatal("listed container not cached")
+	}
+	if container.Name != "web" || container.Image != "nginx:1.25" || container.Labels["svc"] != "front" {
+		t.Errorf("unexpected container %+v", container)
+	}
+
+	tmpl := template.Must(template.New("docker").Parse(`docker.{{.Name}}.{{index .Labels "svc"}}`))
+	if name, err := dockerName(tmpl, container); err != nil || name != "docker.web.front" {
+		t.Errorf("got name %q, %v", name, err)
+	}
+}
+
+func TestDockerLookup(t *testing.T) {
+	fd := newFakeDocker(t)
+	d := newDockerClient(fd.socket)
+	defer d.close()
+
+	if err := d.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	go d.resolve()
+
+	// Misses are resolved in the background
+	if _, ok := d.container(dockerDb); ok {
+		t.Fatal("uncached container resolved synchronously")
+	}
+	eventually(t, "the container lookup", func() bool {
+		_, ok := d.container(dockerDb)
+		return ok
+	})
+
+	container, _ := d.container(dockerDb)
+	if container.Name != "db" || container.Image != "postgres" || container.Labels["svc"] != "back" {
+		t.Errorf("unexpected container %+v", container)
+	}
+
+	// Unknown IDs are cached as such and not looked up again
+	d.container(dockerUnknown)
+	eventually(t, "the unknown container lookup", func() bool {
+		_, found := cached(d, dockerUnknown)
+		return found
+	})
+
+	if container, found := cached(d, dockerUnknown); !found || container != nil {
+		t.Errorf("unknown container cached as %+v, %v", container, found)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, ok := d.container(dockerUnknown); ok {
+			t.Error("unknown container resolved")
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if n := fd.inspected(dockerUnknown); n != 1 {
+		t.Errorf("unknown container inspected %d times, expected 1", n)
+	}
+}
+
+func TestDockerUnavailable(t *testing.T) {
+	fd := newFakeDocker(t)
+	fd.down = true
+
+	d := newDockerClient(fd.socket)
+	defer d.close()
+
+	if err := d.refresh(); err == nil {
+		t.Fatal("refresh of an unavailable daemon succeeded")
+	}
+	go d.resolve()
+
+	d.container(dockerDb)
+	time.Sleep(50 * time.Millisecond)
+
+	if n := fd.inspected(dockerDb); n != 0 {
+		t.Errorf("container inspected %d times while docker is unavailable", n)
+	}
+}
+
+func TestDockerEvents(t *testing.T) {
+	fd := newFakeDocker(t)
+	d := newDockerClient(fd.socket)
+	defer d.close()
+
+	if err := d.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	go d.watch()
+
+	fd.events <- fmt.Sprintf(`{"Action":"start","Actor":{"ID":"%s"}}`, dockerDb)
+	eventually(t, "the start event", func() bool {
+		_, ok := d.container(dockerDb)
+		return ok
+	})
+
+	fd.events <- fmt.Sprintf(`{"Action":"destroy","Actor":{"ID":"%s"}}`, dockerWeb)
+	eventually(t, "the destroy event", func() bool {
+		_, found := cached(d, dockerWeb)
+		return !found
+	})
+}
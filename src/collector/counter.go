@@ -0,0 +1,32 @@
+package collector
+
+import "math"
+
+// counterDelta returns the increase of a counter between two reads. A
+// counter lower than on the previous read was reset, by a driver reload or a
+// device being recreated, in which case ok is false and the interval should
+// not be reported.
+func counterDelta(value uint64, pvalue uint64) (delta uint64, ok bool) {
+	if value >= pvalue {
+		return value - pvalue, true
+	}
+	return 0, false
+}
+
+// counterDelta32 is counterDelta for the counters the kernel keeps in 32 bits
+// whatever the architecture, such as the ms columns of /proc/diskstats. Those
+// wrap around every 2^32 and a lower value is taken as a wrap when the
+// increase it implies is below half the counter range, as a reset otherwise.
+func counterDelta32(value uint64, pvalue uint64) (delta uint64, ok bool) {
+	if value >= pvalue {
+		return value - pvalue, true
+	}
+
+	if pvalue <= math.MaxUint32 {
+		if delta = value + (math.MaxUint32 - pvalue) + 1; delta <= math.MaxUint32/2 {
+			return delta, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,57 @@
+package collector
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  uint64
+		pvalue uint64
+		delta  uint64
+		ok     bool
+	}{
+		{"increase", 1500, 1000, 500, true},
+		{"unchanged", 1000, 1000, 0, true},
+		{"64 bit increase", 1 << 40, 1<<40 - 10, 10, true},
+		{"reset to zero", 0, 3000000000, 0, false},
+		{"reset below 32 bits", 1, 3000000000, 0, false},
+		{"reset above 32 bits", 10, 1 << 40, 0, false},
+		{"decrease at the 32 bits limit", 5, math.MaxUint32, 0, false},
+	}
+
+	for _, test := range tests {
+		delta, ok := counterDelta(test.value, test.pvalue)
+		if delta != test.delta || ok != test.ok {
+			t.Errorf("%s: counterDelta(%d, %d) = %d, %v, expected %d, %v",
+				test.name, test.value, test.pvalue, delta, ok, test.delta, test.ok)
+		}
+	}
+}
+
+func TestCounterDelta32(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  uint64
+		pvalue uint64
+		delta  uint64
+		ok     bool
+	}{
+		{"increase", 1500, 1000, 500, true},
+		{"wrap", 5, math.MaxUint32 - 4, 10, true},
+		{"wrap to zero", 0, math.MaxUint32, 1, true},
+		{"wrap from 3000000000", 1, 3000000000, 1294967297, true},
+		{"reset", 1, 1000000, 0, false},
+		{"reset above 32 bits", 10, 1 << 40, 0, false},
+	}
+
+	for _, test := range tests {
+		delta, ok := counterDelta32(test.value, test.pvalue)
+		if delta != test.delta || ok != test.ok {
+			t.Errorf("%s: counterDelta32(%d, %d) = %d, %v, expected %d, %v",
+				test.name, test.value, test.pvalue, delta, ok, test.delta, test.ok)
+		}
+	}
+}
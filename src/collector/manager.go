@@ -29,11 +29,22 @@ func (m *Manager) Config(mc map[string]interface{}) {
 	m.collectors = map[string]interface {
 		Collector
 	}{
-		"CpuCollector":    &CpuCollector{},
-		"MemCollector":    &MemCollector{},
-		"DiskCollector":   &DiskCollector{},
-		"NetCollector":    &NetCollector{},
-		"StatsdCollector": &StatsdCollector{},
+		"CpuCollector":       &CpuCollector{},
+		"MemCollector":       &MemCollector{},
+		"DiskCollector":      &DiskCollector{},
+		"NetCollector":       &NetCollector{},
+		"StatsdCollector":    &StatsdCollector{},
+		"PsiCollector":       &PsiCollector{},
+		"ThermalCollector":   &ThermalCollector{},
+		"VmstatCollector":    &VmstatCollector{},
+		"NumaCollector":      &NumaCollector{},
+		"FsCollector":        &FsCollector{},
+		"NetstatCollector":   &NetstatCollector{},
+		"SockCollector":      &SockCollector{},
+		"ProcessCollector":   &ProcessCollector{},
+		"ProcStateCollector": &ProcStateCollector{},
+		"CgroupCollector":    &CgroupCollector{},
+		"LimitsCollector":    &LimitsCollector{},
 	}
 
 	m.outputs = map[string]interface {
@@ -50,6 +61,14 @@ func (m *Manager) Config(mc map[string]interface{}) {
 		}).Config(map[string]interface{}(oc.(map[string]interface{})))
 	}
 
+	if root, ok := mc["procfs"].(string); ok && root != "" {
+		procRoot = root
+	}
+
+	if root, ok := mc["sysfs"].(string); ok && root != "" {
+		sysRoot = root
+	}
+
 	fmt.Printf("Configuring Collectors %#v\n", mc)
 
 	for cn, cc := range mc["collectors"].(map[string]interface{}) {
@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"regexp"
+	"strings"
+)
+
+// metricPart turns a device, zone or mount name into a single metric name
+// component, replacing dots, slashes and any other unsafe character by '_'.
+func metricPart(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// metricPath turns a dotted name, such as the expansion of a configured name
+// template, into metric name components.
+func metricPath(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = metricPart(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// NameFilter selects names matching one of the Include patterns, or any name
+// when there are none, and none of the Exclude patterns.
+type NameFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func compilePatterns(patterns []string) []*regexp.Regexp {
+	var ret []*regexp.Regexp
+	for _, pattern := range patterns {
+		ret = append(ret, regexp.MustCompile(pattern))
+	}
+	return ret
+}
+
+func NewNameFilter(include []string, exclude []string) *NameFilter {
+	return &NameFilter{
+		include: compilePatterns(include),
+		exclude: compilePatterns(exclude),
+	}
+}
+
+// Match reports whether any of the names of an object, such as a kernel
+// device name and its alias, is selected by the filter.
+func (f *NameFilter) Match(names ...string) bool {
+	for _, re := range f.exclude {
+		for _, name := range names {
+			if re.MatchString(name) {
+				return false
+			}
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+
+	for _, re := range f.include {
+		for _, name := range names {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,436 @@
+package collector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	. "github.com/Searchlight/khronus-go-client"
+	"github.com/mitchellh/mapstructure"
+)
+
+// Limits at or above this are taken as unlimited. cgroup v1 reports no limit
+// as a value close to the largest signed 64 bits one, which depends on the
+// page size.
+const cgroupUnlimited = 1 << 62
+
+// CgroupGroup names the cgroups whose path matches Pattern.
+type CgroupGroup struct {
+	Pattern string // Matched against the cgroup path
+	Name    string // Metric name, with $1 expanded to the first submatch
+}
+
+// Default cgroups reported, docker containers, kubernetes pods and systemd
+// services.
+var defaultCgroupGroups = []CgroupGroup{
+	{`docker[-/]([0-9a-f]{12})[0-9a-f]{52}(\.scope)?$`, "docker.$1"},
+	{`kubepods.*[/-]pod([0-9a-f_-]+)(\.slice)?$`, "kubepods.$1"},
+	{`^/system\.slice/([^/]+)\.service$`, "systemd.$1"},
+}
+
+type CgroupStats struct {
+	CpuUsage      uint64 // Microseconds of cpu used
+	CpuUser       uint64
+	CpuSystem     uint64
+	NrPeriods     uint64 // Enforcement periods of the cpu quota elapsed
+	NrThrottled   uint64 // Periods the cgroup was throttled on
+	ThrottledUsec uint64
+	MemoryCurrent uint64 // Bytes
+	MemoryLimit   uint64 // Bytes, zero when unlimited
+	OomEvents     uint64 // Times the memory limit was hit, only on v2
+	OomKills      uint64
+	IoReadBytes   uint64
+	IoWriteBytes  uint64
+	IoReads       uint64
+	IoWrites      uint64
+	Pids          uint64
+	PidsLimit     uint64 // Zero when unlimited
+}
+
+// cgroupV2 reports whether the unified hierarchy is mounted on sys/fs/cgroup.
+func cgroupV2() bool {
+	_, err := os.Stat(sysPath("fs", "cgroup", "cgroup.controllers"))
+	return err == nil
+}
+
+// readLimit reads a limit file, "max" or a value above cgroupUnlimited
+// meaning there is none.
+func readLimit(path string) uint64 {
+	value, err := readString(path)
+	if err != nil || value == "max" {
+		return 0
+	}
+
+	limit, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || limit >= cgroupUnlimited {
+		return 0
+	}
+	return limit
+}
+
+// parseIoStat sums the "major:minor rbytes=N wbytes=N rios=N wios=N" lines of
+// a v2 io.stat over every device.
+func parseIoStat(path string, stats *CgroupStats) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+
+			value, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				return err
+			}
+
+			switch kv[0] {
+			case "rbytes":
+				stats.IoReadBytes += value
+			case "wbytes":
+				stats.IoWriteBytes += value
+			case "rios":
+				stats.IoReads += value
+			case "wios":
+				stats.IoWrites += value
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseBlkio sums the "major:minor Read|Write N" lines of a v1 blkio file
+// over every device.
+func parseBlkio(path string) (read uint64, write uint64, err error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		switch fields[1] {
+		case "Read":
+			read += value
+		case "Write":
+			write += value
+		}
+	}
+
+	return read, write, nil
+}
+
+// getCgroupV2Stats reads the controllers files of a cgroup of the unified
+// hierarchy, the ones of a disabled controller are missing.
+func getCgroupV2Stats(path string) CgroupStats {
+	var ret CgroupStats
+	dir := sysPath("fs", "cgroup", path)
+
+	if cpu, err := parseNodeFile(filepath.Join(dir, "cpu.stat")); err == nil {
+		ret.CpuUsage = cpu["usage_usec"]
+		ret.CpuUser = cpu["user_usec"]
+		ret.CpuSystem = cpu["system_usec"]
+		ret.NrPeriods = cpu["nr_periods"]
+		ret.NrThrottled = cpu["nr_throttled"]
+		ret.ThrottledUsec = cpu["throttled_usec"]
+	}
+
+	ret.MemoryCurrent, _ = readUint(filepath.Join(dir, "memory.current"))
+	ret.MemoryLimit = readLimit(filepath.Join(dir, "memory.max"))
+
+	if events, err := parseNodeFile(filepath.Join(dir, "memory.events")); err == nil {
+		ret.OomEvents = events["oom"]
+		ret.OomKills = events["oom_kill"]
+	}
+
+	parseIoStat(filepath.Join(dir, "io.stat"), &ret)
+
+	ret.Pids, _ = readUint(filepath.Join(dir, "pids.current"))
+	ret.PidsLimit = readLimit(filepath.Join(dir, "pids.max"))
+
+	return ret
+}
+
+// getCgroupV1Stats reads a cgroup from the hierarchy of each controller, the
+// ones it is not part of are missing.
+func getCgroupV1Stats(path string) CgroupStats {
+	var ret CgroupStats
+	controller := func(name string, file string) string {
+		return sysPath("fs", "cgroup", name, path, file)
+	}
+
+	if usage, err := readUint(controller("cpuacct", "cpuacct.usage")); err == nil {
+		ret.CpuUsage = usage / 1000
+	}
+
+	if cpu, err := parseNodeFile(controller("cpuacct", "cpuacct.stat")); err == nil {
+		ret.CpuUser = cpu["user"] * 1000000 / userHz
+		ret.CpuSystem = cpu["system"] * 1000000 / userHz
+	}
+
+	if cpu, err := parseNodeFile(controller("cpu", "cpu.stat")); err == nil {
+		ret.NrPeriods = cpu["nr_periods"]
+		ret.NrThrottled = cpu["nr_throttled"]
+		ret.ThrottledUsec = cpu["throttled_time"] / 1000
+	}
+
+	ret.MemoryCurrent, _ = readUint(controller("memory", "memory.usage_in_bytes"))
+	ret.MemoryLimit = readLimit(controller("memory", "memory.limit_in_bytes"))
+
+	if oom, err := parseNodeFile(controller("memory", "memory.oom_control")); err == nil {
+		ret.OomKills = oom["oom_kill"]
+	}
+
+	ret.IoReadBytes, ret.IoWriteBytes, _ = parseBlkio(controller("blkio", "blkio.throttle.io_service_bytes"))
+	ret.IoReads, ret.IoWrites, _ = parseBlkio(controller("blkio", "blkio.throttle.io_serviced"))
+
+	ret.Pids, _ = readUint(controller("pids", "pids.current"))
+	ret.PidsLimit = readLimit(controller("pids", "pids.max"))
+
+	return ret
+}
+
+type cgroupPattern struct {
+	re   *regexp.Regexp
+	name string
+}
+
+type CgroupCollector struct {
+	host         string
+	Interval     int64
+	Groups       []CgroupGroup // Cgroups reported, a cgroup takes the name of the first group it matches
+	Docker       bool          // Name the docker containers after their metadata
+	DockerSocket string        // Docker Engine API socket
+	DockerName   string        // Template of the container names, given its ID, Name, Image and Labels
+	patterns     []cgroupPattern
+	nameTemplate *template.Template
+	docker       *dockerClient
+	previous     map[string]CgroupStats
+}
+
+func (cc *CgroupCollector) Config(config map[string]interface{}) {
+	fmt.Printf("%s config %#v\n", cc.Name(), cc)
+
+	cc.host, _ = os.Hostname()
+
+	if err := mapstructure.Decode(config, cc); err != nil {
+		panic(err)
+	}
+
+	if cc.Interval == 0 {
+		cc.Interval = 1
+	}
+
+	if len(cc.Groups) == 0 {
+		cc.Groups = defaultCgroupGroups
+	}
+
+	cc.patterns = nil
+	for _, group := range cc.Groups {
+		cc.patterns = append(cc.patterns, cgroupPattern{regexp.MustCompile(group.Pattern), group.Name})
+	}
+
+	if cc.DockerSocket == "" {
+		cc.DockerSocket = "/var/run/docker.sock"
+	}
+
+	if cc.DockerName == "" {
+		cc.DockerName = "docker.{{.Name}}"
+	}
+
+	cc.nameTemplate = template.Must(template.New("docker").Parse(cc.DockerName))
+
+	cc.previous = make(map[string]CgroupStats)
+
+	fmt.Printf("%s config %#v\n", cc.Name(), cc)
+}
+
+func (cc *CgroupCollector) Detect() bool {
+	if runtime.GOOS == "linux" {
+		return true
+	} else {
+		return false
+	}
+}
+
+// name returns the metric name of a cgroup path, or false when no pattern
+// matches it. The cgroups of the containers docker knows about are named
+// after the DockerName template instead, the others keep their pattern name
+// until their container is resolved.
+func (cc *CgroupCollector) name(path string) (string, bool) {
+	for _, p := range cc.patterns {
+		match := p.re.FindStringSubmatchIndex(path)
+		if match == nil {
+			continue
+		}
+
+		if cc.docker != nil {
+			if id := dockerIdRegex.FindString(path); id != "" {
+				if container, ok := cc.docker.container(id); ok {
+					name, err := dockerName(cc.nameTemplate, container)
+					if err == nil {
+						return name, true
+					}
+					fmt.Println(err)
+				}
+			}
+		}
+
+		return metricPath(string(p.re.ExpandString(nil, p.name, path, match))), true
+	}
+	return "", false
+}
+
+// getCgroups walks the cgroup hierarchies and returns the path of the
+// cgroups reported, by name. When several cgroups get the same name the
+// first one found, the outermost, is kept.
+func (cc *CgroupCollector) getCgroups(v2 bool) map[string]string {
+	roots := []string{sysPath("fs", "cgroup")}
+	if !v2 {
+		roots = nil
+		for _, controller := range []string{"cpuacct", "cpu", "memory", "blkio", "pids"} {
+			roots = append(roots, sysPath("fs", "cgroup", controller))
+		}
+	}
+
+	ret := make(map[string]string)
+
+	for _, root := range roots {
+		// v1 controllers are usually symlinks to a joint hierarchy such as
+		// cpu,cpuacct
+		root, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			continue
+		}
+
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() {
+				return nil
+			}
+
+			cgroup := "/" + strings.TrimPrefix(strings.TrimPrefix(path, root), "/")
+			if name, ok := cc.name(cgroup); ok {
+				if _, found := ret[name]; !found {
+					ret[name] = cgroup
+				}
+			}
+			return nil
+		})
+	}
+
+	return ret
+}
+
+func (cc *CgroupCollector) emit(c chan *Metric, name string, v CgroupStats, pv CgroupStats) {
+	prefix := cc.host + ".cgroup." + name
+	usec := uint64(cc.Interval) * 1000000
+
+	// A counter lower than on the previous read belongs to a new cgroup
+	// reusing the path
+	if v.CpuUsage >= pv.CpuUsage && v.CpuUser >= pv.CpuUser && v.CpuSystem >= pv.CpuSystem {
+		c <- Gauge(prefix + ".cpu.usage").Record(percent(v.CpuUsage-pv.CpuUsage, usec))
+		c <- Gauge(prefix + ".cpu.user").Record(percent(v.CpuUser-pv.CpuUser, usec))
+		c <- Gauge(prefix + ".cpu.system").Record(percent(v.CpuSystem-pv.CpuSystem, usec))
+	}
+
+	if v.NrPeriods >= pv.NrPeriods && v.NrThrottled >= pv.NrThrottled && v.ThrottledUsec >= pv.ThrottledUsec {
+		c <- Gauge(prefix + ".cpu.throttled_periods").Record(v.NrThrottled - pv.NrThrottled)
+		c <- Gauge(prefix + ".cpu.throttled_percent").Record(percent(v.NrThrottled-pv.NrThrottled, v.NrPeriods-pv.NrPeriods))
+		c <- Gauge(prefix + ".cpu.throttled_usec").Record((v.ThrottledUsec - pv.ThrottledUsec) / uint64(cc.Interval))
+	}
+
+	c <- Gauge(prefix + ".memory.current").Record(v.MemoryCurrent)
+	if v.MemoryLimit > 0 {
+		c <- Gauge(prefix + ".memory.limit").Record(v.MemoryLimit)
+		c <- Gauge(prefix + ".memory.used_percent").Record(percent(v.MemoryCurrent, v.MemoryLimit))
+	}
+
+	if v.OomEvents >= pv.OomEvents && v.OomKills >= pv.OomKills {
+		c <- Gauge(prefix + ".memory.oom_events").Record(v.OomEvents - pv.OomEvents)
+		c <- Gauge(prefix + ".memory.oom_kills").Record(v.OomKills - pv.OomKills)
+	}
+
+	if v.IoReadBytes >= pv.IoReadBytes && v.IoWriteBytes >= pv.IoWriteBytes && v.IoReads >= pv.IoReads && v.IoWrites >= pv.IoWrites {
+		c <- Gauge(prefix + ".io.read_bytes").Record((v.IoReadBytes - pv.IoReadBytes) / uint64(cc.Interval))
+		c <- Gauge(prefix + ".io.write_bytes").Record((v.IoWriteBytes - pv.IoWriteBytes) / uint64(cc.Interval))
+		c <- Gauge(prefix + ".io.reads").Record((v.IoReads - pv.IoReads) / uint64(cc.Interval))
+		c <- Gauge(prefix + ".io.writes").Record((v.IoWrites - pv.IoWrites) / uint64(cc.Interval))
+	}
+
+	c <- Gauge(prefix + ".pids.current").Record(v.Pids)
+	if v.PidsLimit > 0 {
+		c <- Gauge(prefix + ".pids.limit").Record(v.PidsLimit)
+	}
+}
+
+func (cc *CgroupCollector) Run(c chan *Metric) {
+
+	if !cc.Detect() {
+		return
+	}
+
+	if cc.Docker {
+		cc.docker = newDockerClient(cc.DockerSocket)
+		defer cc.docker.close()
+
+		if err := cc.docker.refresh(); err != nil {
+			fmt.Println(err)
+		}
+		go cc.docker.watch()
+		go cc.docker.resolve()
+	}
+
+	for {
+		v2 := cgroupV2()
+		current := make(map[string]CgroupStats)
+
+		for name, path := range cc.getCgroups(v2) {
+			var stats CgroupStats
+			if v2 {
+				stats = getCgroupV2Stats(path)
+			} else {
+				stats = getCgroupV1Stats(path)
+			}
+
+			// Rates need a previous read of the same cgroup
+			if pstats, ok := cc.previous[path]; ok {
+				cc.emit(c, name, stats, pstats)
+			}
+			current[path] = stats
+		}
+
+		cc.previous = current
+
+		time.Sleep(time.Duration(cc.Interval) * time.Second)
+	}
+}
+
+func (*CgroupCollector) Name() string {
+	return "linux.cgroup.stats"
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -33,49 +35,42 @@ type CpuStat struct {
 type CpuStats struct {
 	Total       CpuStat
 	Cpus        []CpuStat
-	Intr        []uint64
-	Ctxt        uint64
-	BootTime    uint64
-	Processes   uint64
-	ProcRunning uint64
-	ProcBlocked uint64
-	SoftIrq     []uint64
+	Intr        []uint64 // Total interrupts serviced followed by the count of every irq
+	Ctxt        uint64   // Context switches
+	BootTime    uint64   // Boot time in seconds since the epoch
+	Processes   uint64   // Forks since boot
+	ProcRunning uint64   // Processes in runnable state
+	ProcBlocked uint64   // Processes blocked waiting for I/O
+	SoftIrq     []uint64 // Total softirqs serviced followed by the count of every type
 }
 
+// Names of the per type softirq counters, in /proc/stat order.
+var softIrqNames = []string{"hi", "timer", "net_tx", "net_rx", "block", "irq_poll", "tasklet", "sched", "hrtimer", "rcu"}
+
+// parseCpu parses a cpu line of /proc/stat. Older kernels expose fewer
+// columns, iowait, irq and softirq appeared in 2.6, steal in 2.6.11, guest
+// in 2.6.24 and guest_nice in 2.6.33. Missing columns are left at zero.
 func parseCpu(cpu *CpuStat, fields []string) error {
-	cpu.Name = fields[0]
+	if len(fields) < 5 {
+		return fmt.Errorf("%s has %d columns, expected at least 4", fields[0], len(fields)-1)
+	}
 
-	var err error
+	cpu.Name = fields[0]
 
-	if cpu.User, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
-		return err
-	}
-	if cpu.Nice, err = strconv.ParseUint(fields[2], 10, 64); err != nil {
-		return err
-	}
-	if cpu.Sys, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
-		return err
-	}
-	if cpu.Idle, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
-		return err
-	}
-	if cpu.Wait, err = strconv.ParseUint(fields[5], 10, 64); err != nil {
-		return err
-	}
-	if cpu.Irq, err = strconv.ParseUint(fields[6], 10, 64); err != nil {
-		return err
-	}
-	if cpu.SoftIrq, err = strconv.ParseUint(fields[7], 10, 64); err != nil {
-		return err
+	columns := []*uint64{
+		&cpu.User, &cpu.Nice, &cpu.Sys, &cpu.Idle, &cpu.Wait,
+		&cpu.Irq, &cpu.SoftIrq, &cpu.Stolen, &cpu.Guest, &cpu.GuestNice,
 	}
-	if cpu.Stolen, err = strconv.ParseUint(fields[8], 10, 64); err != nil {
-		return err
-	}
-	if cpu.Guest, err = strconv.ParseUint(fields[9], 10, 64); err != nil {
-		return err
-	}
-	if cpu.GuestNice, err = strconv.ParseUint(fields[10], 10, 64); err != nil {
-		return err
+
+	for k, v := range fields[1:] {
+		if k >= len(columns) {
+			break
+		}
+
+		var err error
+		if *columns[k], err = strconv.ParseUint(v, 10, 64); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -91,7 +86,7 @@ type LoadAverage struct {
 }
 
 func getLoadAverage() (*LoadAverage, error) {
-	loadStats := "/proc/loadavg"
+	loadStats := procPath("loadavg")
 	ret := LoadAverage{}
 
 	if _, err := os.Stat(loadStats); err != nil {
@@ -142,7 +137,7 @@ func getLoadAverage() (*LoadAverage, error) {
 }
 
 func getCpuStats() (*CpuStats, error) {
-	procStats := "/proc/stat"
+	procStats := procPath("stat")
 	ret := CpuStats{}
 
 	if _, err := os.Stat(procStats); err != nil {
@@ -163,6 +158,10 @@ func getCpuStats() (*CpuStats, error) {
 
 		fields := strings.Fields(string(line))
 
+		if len(fields) < 2 {
+			continue
+		}
+
 		if string(line[0:3]) == "cpu" {
 			cpu := CpuStat{}
 			err := parseCpu(&cpu, fields)
@@ -177,7 +176,6 @@ func getCpuStats() (*CpuStats, error) {
 		} else {
 			switch fields[0] {
 			case "intr":
-				continue
 				for _, v := range fields[1:] {
 					uif, err := strconv.ParseUint(v, 10, 64)
 					if err != nil {
@@ -186,37 +184,31 @@ func getCpuStats() (*CpuStats, error) {
 					ret.Intr = append(ret.Intr, uif)
 				}
 			case "ctxt":
-				continue
 				ret.Ctxt, err = strconv.ParseUint(fields[1], 10, 64)
 				if err != nil {
 					return nil, err
 				}
 			case "btime":
-				continue
 				ret.BootTime, err = strconv.ParseUint(fields[1], 10, 64)
 				if err != nil {
 					return nil, err
 				}
 			case "processes":
-				continue
 				ret.Processes, err = strconv.ParseUint(fields[1], 10, 64)
 				if err != nil {
 					return nil, err
 				}
 			case "procs_running":
-				continue
 				ret.ProcRunning, err = strconv.ParseUint(fields[1], 10, 64)
 				if err != nil {
 					return nil, err
 				}
 			case "procs_blocked":
-				continue
 				ret.ProcBlocked, err = strconv.ParseUint(fields[1], 10, 64)
 				if err != nil {
 					return nil, err
 				}
 			case "softirq":
-				continue
 				for _, v := range fields[1:] {
 					uif, err := strconv.ParseUint(v, 10, 64)
 					if err != nil {
@@ -230,11 +222,75 @@ func getCpuStats() (*CpuStats, error) {
 	return &ret, nil
 }
 
+// CpuPercents is the share of time a cpu spent in every state between two
+// samples. The kernel accounts guest time in user time as well, so User and
+// Nice exclude Guest and GuestNice and all of them add up to 100.
+type CpuPercents struct {
+	User      float64
+	Nice      float64
+	Sys       float64
+	Idle      float64
+	Wait      float64
+	Irq       float64
+	SoftIrq   float64
+	Stolen    float64
+	Guest     float64
+	GuestNice float64
+}
+
+// Busy is the share of time the cpu was neither idle nor waiting for I/O.
+func (p CpuPercents) Busy() float64 {
+	return 100 - p.Idle - p.Wait
+}
+
+func getCpuPercents(cpu CpuStat, pcpu CpuStat) CpuPercents {
+	ret := CpuPercents{}
+
+	tc := float64((cpu.Idle - pcpu.Idle) +
+		(cpu.Irq - pcpu.Irq) +
+		(cpu.SoftIrq - pcpu.SoftIrq) +
+		(cpu.Stolen - pcpu.Stolen) +
+		(cpu.Sys - pcpu.Sys) +
+		(cpu.User - pcpu.User) +
+		(cpu.Nice - pcpu.Nice) +
+		(cpu.Wait - pcpu.Wait))
+
+	if tc == 0 {
+		return ret
+	}
+
+	guest := cpu.Guest - pcpu.Guest
+	if guest > cpu.User-pcpu.User {
+		guest = cpu.User - pcpu.User
+	}
+
+	guestNice := cpu.GuestNice - pcpu.GuestNice
+	if guestNice > cpu.Nice-pcpu.Nice {
+		guestNice = cpu.Nice - pcpu.Nice
+	}
+
+	ret.User = float64(cpu.User-pcpu.User-guest) * 100 / tc
+	ret.Nice = float64(cpu.Nice-pcpu.Nice-guestNice) * 100 / tc
+	ret.Sys = float64(cpu.Sys-pcpu.Sys) * 100 / tc
+	ret.Idle = float64(cpu.Idle-pcpu.Idle) * 100 / tc
+	ret.Wait = float64(cpu.Wait-pcpu.Wait) * 100 / tc
+	ret.Irq = float64(cpu.Irq-pcpu.Irq) * 100 / tc
+	ret.SoftIrq = float64(cpu.SoftIrq-pcpu.SoftIrq) * 100 / tc
+	ret.Stolen = float64(cpu.Stolen-pcpu.Stolen) * 100 / tc
+	ret.Guest = float64(guest) * 100 / tc
+	ret.GuestNice = float64(guestNice) * 100 / tc
+
+	return ret
+}
+
 type CpuCollector struct {
-	cpuload  LoadAverage
-	cpustats CpuStats
-	host     string
-	Interval int64
+	cpuload     LoadAverage
+	cpustats    CpuStats
+	host        string
+	Interval    int64
+	PerCpu      bool // Emit the percentages of every core
+	BusiestCpus int  // Only emit the N busiest cores, all of them when 0
+	CpuSummary  bool // Emit min, max and stddev of the busy percentage of the cores
 }
 
 func (cc *CpuCollector) Config(config map[string]interface{}) {
@@ -246,6 +302,9 @@ func (cc *CpuCollector) Config(config map[string]interface{}) {
 		panic(err)
 	}
 
+	if cc.Interval == 0 {
+		cc.Interval = 1
+	}
 }
 
 func (cc *CpuCollector) Detect() bool {
@@ -256,14 +315,130 @@ func (cc *CpuCollector) Detect() bool {
 	}
 }
 
+func (cc *CpuCollector) emitPercents(c chan *Metric, prefix string, p CpuPercents) {
+	c <- Gauge(prefix + ".idle").Record(uint64(p.Idle))
+	c <- Gauge(prefix + ".irq").Record(uint64(p.Irq))
+	c <- Gauge(prefix + ".softirq").Record(uint64(p.SoftIrq))
+	c <- Gauge(prefix + ".stolen").Record(uint64(p.Stolen))
+	c <- Gauge(prefix + ".sys").Record(uint64(p.Sys))
+	c <- Gauge(prefix + ".user").Record(uint64(p.User))
+	c <- Gauge(prefix + ".nice").Record(uint64(p.Nice))
+	c <- Gauge(prefix + ".wait").Record(uint64(p.Wait))
+	c <- Gauge(prefix + ".guest").Record(uint64(p.Guest))
+	c <- Gauge(prefix + ".guest_nice").Record(uint64(p.GuestNice))
+}
+
+// emitCores publishes the per core percentages and their summary. Cores are
+// matched by name, as offline cores are missing from /proc/stat.
+func (cc *CpuCollector) emitCores(c chan *Metric, total *CpuStats, ptotal *CpuStats) {
+	previous := make(map[string]CpuStat, len(ptotal.Cpus))
+	for _, cpu := range ptotal.Cpus {
+		previous[cpu.Name] = cpu
+	}
+
+	var names []string
+	var cores []CpuPercents
+
+	for _, cpu := range total.Cpus {
+		pcpu, ok := previous[cpu.Name]
+		if !ok {
+			continue
+		}
+		names = append(names, cpu.Name)
+		cores = append(cores, getCpuPercents(cpu, pcpu))
+	}
+
+	if len(cores) == 0 {
+		return
+	}
+
+	if cc.PerCpu {
+		emit := make([]int, len(cores))
+		for k := range emit {
+			emit[k] = k
+		}
+
+		if cc.BusiestCpus > 0 && cc.BusiestCpus < len(emit) {
+			sort.SliceStable(emit, func(i, j int) bool {
+				return cores[emit[i]].Busy() > cores[emit[j]].Busy()
+			})
+			emit = emit[:cc.BusiestCpus]
+		}
+
+		for _, k := range emit {
+			prefix := cc.host + ".cpu." + strings.TrimPrefix(names[k], "cpu")
+			cc.emitPercents(c, prefix, cores[k])
+		}
+	}
+
+	if cc.CpuSummary {
+		min, max, sum := 100.0, 0.0, 0.0
+		for _, p := range cores {
+			busy := p.Busy()
+			min = math.Min(min, busy)
+			max = math.Max(max, busy)
+			sum += busy
+		}
+
+		mean := sum / float64(len(cores))
+		variance := 0.0
+		for _, p := range cores {
+			variance += (p.Busy() - mean) * (p.Busy() - mean)
+		}
+		variance /= float64(len(cores))
+
+		c <- Gauge(cc.host + ".cpu_cores.busy.min").Record(uint64(math.Max(min, 0)))
+		c <- Gauge(cc.host + ".cpu_cores.busy.max").Record(uint64(math.Max(max, 0)))
+		c <- Gauge(cc.host + ".cpu_cores.busy.stddev").Record(uint64(math.Sqrt(variance)))
+	}
+}
+
+func (cc *CpuCollector) rate(value uint64, pvalue uint64) uint64 {
+	if value < pvalue {
+		return 0
+	}
+	return (value - pvalue) / uint64(cc.Interval)
+}
+
+// emitKernel publishes the scheduler and interrupt activity of the interval.
+func (cc *CpuCollector) emitKernel(c chan *Metric, total *CpuStats, ptotal *CpuStats) {
+	c <- Gauge(cc.host + ".kernel.context_switches").Record(cc.rate(total.Ctxt, ptotal.Ctxt))
+	c <- Gauge(cc.host + ".kernel.forks").Record(cc.rate(total.Processes, ptotal.Processes))
+
+	if len(total.Intr) > 0 && len(ptotal.Intr) > 0 {
+		c <- Gauge(cc.host + ".kernel.interrupts").Record(cc.rate(total.Intr[0], ptotal.Intr[0]))
+	}
+
+	for k, v := range total.SoftIrq {
+		if k >= len(ptotal.SoftIrq) || k > len(softIrqNames) {
+			break
+		}
+
+		name := "total"
+		if k > 0 {
+			name = softIrqNames[k-1]
+		}
+		c <- Gauge(cc.host + ".kernel.softirqs." + name).Record(cc.rate(v, ptotal.SoftIrq[k]))
+	}
+
+	c <- Gauge(cc.host + ".kernel.procs_running").Record(total.ProcRunning)
+	c <- Gauge(cc.host + ".kernel.procs_blocked").Record(total.ProcBlocked)
+
+	if now := uint64(time.Now().Unix()); total.BootTime > 0 && now > total.BootTime {
+		c <- Gauge(cc.host + ".kernel.uptime").Record(now - total.BootTime)
+	}
+}
+
 func (cc *CpuCollector) Run(c chan *Metric) {
 
-	// [TODO] Handler Error
+	if !cc.Detect() {
+		return
+	}
 
 	ptotal, err := getCpuStats()
 
 	if err != nil {
-		fmt.Errorf("%s\n", err)
+		fmt.Println(err)
 	}
 
 	time.Sleep(time.Duration(cc.Interval) * time.Second)
@@ -271,34 +446,28 @@ func (cc *CpuCollector) Run(c chan *Metric) {
 	for {
 		total, err := getCpuStats()
 		if err != nil {
-			fmt.Errorf("%s\n", err)
+			fmt.Println(err)
+			time.Sleep(time.Duration(cc.Interval) * time.Second)
+			continue
 		}
+
 		cpuload, err := getLoadAverage()
 		if err != nil {
-			fmt.Errorf("%s\n", err)
+			fmt.Println(err)
+		} else {
+			c <- Gauge(cc.host + ".cpu_load.one").Record(uint64(cpuload.One * 100))
+			c <- Gauge(cc.host + ".cpu_load.five").Record(uint64(cpuload.Five * 100))
+			c <- Gauge(cc.host + ".cpu_load.fifteen").Record(uint64(cpuload.Fifteen * 100))
 		}
 
-		c <- Gauge(cc.host + ".cpu_load.one").Record(uint64(cpuload.One * 100))
-		c <- Gauge(cc.host + ".cpu_load.five").Record(uint64(cpuload.Five * 100))
-		c <- Gauge(cc.host + ".cpu_load.fifteen").Record(uint64(cpuload.Fifteen * 100))
-
-		tc := float64((total.Total.Idle - ptotal.Total.Idle) +
-			(total.Total.Irq - ptotal.Total.Irq) +
-			(total.Total.SoftIrq - ptotal.Total.SoftIrq) +
-			(total.Total.Stolen - ptotal.Total.Stolen) +
-			(total.Total.Sys - ptotal.Total.Sys) +
-			(total.Total.User - ptotal.Total.User) +
-			(total.Total.Nice - ptotal.Total.Nice) +
-			(total.Total.Wait - ptotal.Total.Wait))
-
-		c <- Gauge(cc.host + ".cpu_total.idle").Record(uint64(float64(total.Total.Idle-ptotal.Total.Idle) * 100 / tc))
-		c <- Gauge(cc.host + ".cpu_total.irq").Record(uint64(float64(total.Total.Irq-ptotal.Total.Irq) * 100 / tc))
-		c <- Gauge(cc.host + ".cpu_total.softirq").Record(uint64(float64(total.Total.SoftIrq-ptotal.Total.SoftIrq) * 100 / tc))
-		c <- Gauge(cc.host + ".cpu_total.stolen").Record(uint64(float64(total.Total.Stolen-ptotal.Total.Stolen) * 100 / tc))
-		c <- Gauge(cc.host + ".cpu_total.sys").Record(uint64(float64(total.Total.Sys-ptotal.Total.Sys) * 100 / tc))
-		c <- Gauge(cc.host + ".cpu_total.user").Record(uint64(float64(total.Total.User-ptotal.Total.User) * 100 / tc))
-		c <- Gauge(cc.host + ".cpu_total.nice").Record(uint64(float64(total.Total.Nice-ptotal.Total.Nice) * 100 / tc))
-		c <- Gauge(cc.host + ".cpu_total.wait").Record(uint64(float64(total.Total.Wait-ptotal.Total.Wait) * 100 / tc))
+		if ptotal != nil {
+			cc.emitPercents(c, cc.host+".cpu_total", getCpuPercents(total.Total, ptotal.Total))
+			cc.emitKernel(c, total, ptotal)
+
+			if cc.PerCpu || cc.CpuSummary {
+				cc.emitCores(c, total, ptotal)
+			}
+		}
 
 		ptotal = total
 
@@ -7,7 +7,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"regexp"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -32,10 +32,23 @@ type DiskStats struct {
 	IosInProgress     uint64 // Number of actual I/O requests currently in flight.
 	MsecTotal         uint64 // Amount of time during which ios_in_progress >= 1.
 	MsecWeightedTotal uint64 // Measure of recent I/O completion time and backlog.
+	DiscardRequests   uint64 // Total number of discards completed successfully.
+	DiscardMerged     uint64 // Adjacent discard requests merged in a single req.
+	DiscardSectors    uint64 // Total number of sectors discarded successfully.
+	MsecDiscard       uint64 // Total number of ms spent by all discards.
+	FlushRequests     uint64 // Total number of flush requests completed successfully.
+	MsecFlush         uint64 // Total number of ms spent by all flush requests.
+	HasDiscard        bool   // Discard columns are exposed since kernel 4.18.
+	HasFlush          bool   // Flush columns are exposed since kernel 5.5.
 }
 
-func getDiskStats() (*[]DiskStats, error) {
-	procDiskStats := "/proc/diskstats"
+// Size of the sectors counted in /proc/diskstats, whatever the device.
+const diskSectorSize = 512
+
+// getDiskStats returns the devices of /proc/diskstats keyed by major:minor,
+// which unlike their position in the file is stable across reads.
+func getDiskStats() (map[string]DiskStats, error) {
+	procDiskStats := procPath("diskstats")
 	if _, err := os.Stat(procDiskStats); err != nil {
 		return nil, fmt.Errorf("%s not exists", procDiskStats)
 	}
@@ -45,7 +58,7 @@ func getDiskStats() (*[]DiskStats, error) {
 		return nil, err
 	}
 
-	var ret []DiskStats
+	ret := make(map[string]DiskStats)
 
 	reader := bufio.NewReader(bytes.NewBuffer(contents))
 	for {
@@ -55,6 +68,13 @@ func getDiskStats() (*[]DiskStats, error) {
 		}
 
 		fields := strings.Fields(string(line))
+
+		size := len(fields)
+		// kernel version too low
+		if size < 14 {
+			continue
+		}
+
 		// shortcut the deduper and just skip disks that
 		// haven't done a single read.  This elimiates a bunch
 		// of loopback, ramdisk, and cdrom devices but still
@@ -64,77 +84,164 @@ func getDiskStats() (*[]DiskStats, error) {
 			continue
 		}
 
-		size := len(fields)
-		// kernel version too low
-		if size != 14 {
-			continue
+		item := DiskStats{}
+
+		if item.Major, err = strconv.Atoi(fields[0]); err != nil {
+			return nil, err
 		}
 
-		item := DiskStats{}
-		for i := 0; i < size; i++ {
-			if item.Major, err = strconv.Atoi(fields[0]); err != nil {
-				return nil, err
-			}
+		if item.Minor, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, err
+		}
 
-			if item.Minor, err = strconv.Atoi(fields[1]); err != nil {
-				return nil, err
-			}
+		item.Device = fields[2]
 
-			item.Device = fields[2]
+		if item.ReadRequests, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
+			return nil, err
+		}
 
-			if item.ReadRequests, err = strconv.ParseUint(fields[3], 10, 64); err != nil {
-				return nil, err
-			}
+		if item.ReadMerged, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
+			return nil, err
+		}
 
-			if item.ReadMerged, err = strconv.ParseUint(fields[4], 10, 64); err != nil {
-				return nil, err
-			}
+		if item.ReadSectors, err = strconv.ParseUint(fields[5], 10, 64); err != nil {
+			return nil, err
+		}
 
-			if item.ReadSectors, err = strconv.ParseUint(fields[5], 10, 64); err != nil {
-				return nil, err
-			}
+		if item.MsecRead, err = strconv.ParseUint(fields[6], 10, 64); err != nil {
+			return nil, err
+		}
 
-			if item.MsecRead, err = strconv.ParseUint(fields[6], 10, 64); err != nil {
-				return nil, err
-			}
+		if item.WriteRequests, err = strconv.ParseUint(fields[7], 10, 64); err != nil {
+			return nil, err
+		}
 
-			if item.WriteRequests, err = strconv.ParseUint(fields[7], 10, 64); err != nil {
-				return nil, err
-			}
+		if item.WriteMerged, err = strconv.ParseUint(fields[8], 10, 64); err != nil {
+			return nil, err
+		}
+
+		if item.WriteSectors, err = strconv.ParseUint(fields[9], 10, 64); err != nil {
+			return nil, err
+		}
+
+		if item.MsecWrite, err = strconv.ParseUint(fields[10], 10, 64); err != nil {
+			return nil, err
+		}
+
+		if item.IosInProgress, err = strconv.ParseUint(fields[11], 10, 64); err != nil {
+			return nil, err
+		}
+
+		if item.MsecTotal, err = strconv.ParseUint(fields[12], 10, 64); err != nil {
+			return nil, err
+		}
+
+		if item.MsecWeightedTotal, err = strconv.ParseUint(fields[13], 10, 64); err != nil {
+			return nil, err
+		}
 
-			if item.WriteMerged, err = strconv.ParseUint(fields[8], 10, 64); err != nil {
+		if size >= 18 {
+			item.HasDiscard = true
+
+			if item.DiscardRequests, err = strconv.ParseUint(fields[14], 10, 64); err != nil {
 				return nil, err
 			}
 
-			if item.WriteSectors, err = strconv.ParseUint(fields[9], 10, 64); err != nil {
+			if item.DiscardMerged, err = strconv.ParseUint(fields[15], 10, 64); err != nil {
 				return nil, err
 			}
 
-			if item.MsecWrite, err = strconv.ParseUint(fields[10], 10, 64); err != nil {
+			if item.DiscardSectors, err = strconv.ParseUint(fields[16], 10, 64); err != nil {
 				return nil, err
 			}
 
-			if item.IosInProgress, err = strconv.ParseUint(fields[11], 10, 64); err != nil {
+			if item.MsecDiscard, err = strconv.ParseUint(fields[17], 10, 64); err != nil {
 				return nil, err
 			}
+		}
 
-			if item.MsecTotal, err = strconv.ParseUint(fields[12], 10, 64); err != nil {
+		if size >= 20 {
+			item.HasFlush = true
+
+			if item.FlushRequests, err = strconv.ParseUint(fields[18], 10, 64); err != nil {
 				return nil, err
 			}
 
-			if item.MsecWeightedTotal, err = strconv.ParseUint(fields[13], 10, 64); err != nil {
+			if item.MsecFlush, err = strconv.ParseUint(fields[19], 10, 64); err != nil {
 				return nil, err
 			}
+		}
+
+		ret[fields[0]+":"+fields[1]] = item
+	}
+	return ret, nil
+}
+
+// Whole disks selected when no Include patterns are configured, partitions
+// are left out as their activity is already accounted in their disk.
+var defaultDiskInclude = []string{
+	"^(sd|hd|vd|xvd)[a-z]+$",
+	"^nvme[0-9]+n[0-9]+$",
+	"^mmcblk[0-9]+$",
+	"^dm-[0-9]+$",
+	"^md[0-9]+$",
+}
+
+type DiskDevice struct {
+	Name   string // Device mapper name of dm devices, the kernel name otherwise
+	Parent string // Disk holding a partition, empty for whole disks
+}
+
+// getDiskDevice resolves the device mapper name and the parent disk of a
+// kernel block device from sysfs.
+func getDiskDevice(device string) DiskDevice {
+	ret := DiskDevice{Name: device}
+
+	if name, err := readString(sysPath("block", device, "dm", "name")); err == nil && name != "" {
+		ret.Name = name
+	}
 
+	if _, err := os.Stat(sysPath("class", "block", device, "partition")); err == nil {
+		if path, err := filepath.EvalSymlinks(sysPath("class", "block", device)); err == nil {
+			ret.Parent = filepath.Base(filepath.Dir(path))
 		}
-		ret = append(ret, item)
 	}
-	return &ret, nil
+
+	return ret
+}
+
+// addDiskStats sums the activity of two devices.
+func addDiskStats(a DiskStats, b DiskStats) DiskStats {
+	a.ReadRequests += b.ReadRequests
+	a.ReadMerged += b.ReadMerged
+	a.ReadSectors += b.ReadSectors
+	a.MsecRead += b.MsecRead
+	a.WriteRequests += b.WriteRequests
+	a.WriteMerged += b.WriteMerged
+	a.WriteSectors += b.WriteSectors
+	a.MsecWrite += b.MsecWrite
+	a.IosInProgress += b.IosInProgress
+	a.MsecTotal += b.MsecTotal
+	a.MsecWeightedTotal += b.MsecWeightedTotal
+	a.DiscardRequests += b.DiscardRequests
+	a.DiscardMerged += b.DiscardMerged
+	a.DiscardSectors += b.DiscardSectors
+	a.MsecDiscard += b.MsecDiscard
+	a.FlushRequests += b.FlushRequests
+	a.MsecFlush += b.MsecFlush
+	a.HasDiscard = a.HasDiscard && b.HasDiscard
+	a.HasFlush = a.HasFlush && b.HasFlush
+	return a
 }
 
 type DiskCollector struct {
-	host     string
-	Interval int64
+	host                string
+	Interval            int64
+	Include             []string // Device name patterns to report, whole disks when empty
+	Exclude             []string // Device name patterns never reported
+	AggregatePartitions bool     // Report partitions as their parent disk
+	filter              *NameFilter
+	devices             map[string]DiskDevice
 }
 
 func (dc *DiskCollector) Config(config map[string]interface{}) {
@@ -146,6 +253,17 @@ func (dc *DiskCollector) Config(config map[string]interface{}) {
 		panic(err)
 	}
 
+	if dc.Interval == 0 {
+		dc.Interval = 1
+	}
+
+	if len(dc.Include) == 0 {
+		dc.Include = defaultDiskInclude
+	}
+
+	dc.filter = NewNameFilter(dc.Include, dc.Exclude)
+	dc.devices = make(map[string]DiskDevice)
+
 	fmt.Printf("%s config %#v\n", dc.Name(), dc)
 }
 
@@ -157,42 +275,181 @@ func (dc *DiskCollector) Detect() bool {
 	}
 }
 
+// await returns the average time in ms spent by each request.
+func await(msec uint64, requests uint64) uint64 {
+	if requests == 0 {
+		return 0
+	}
+	return msec / requests
+}
+
+// emit publishes the iostat equivalent activity of a device between two
+// reads, nothing is published when one of its counters was reset.
+func (dc *DiskCollector) emit(c chan *Metric, v DiskStats, pv DiskStats) {
+	reset := false
+	delta := func(value uint64, pvalue uint64) uint64 {
+		d, ok := counterDelta(value, pvalue)
+		if !ok {
+			reset = true
+		}
+		return d
+	}
+	// The ms columns are unsigned int in the kernel and wrap around
+	msecDelta := func(value uint64, pvalue uint64) uint64 {
+		d, ok := counterDelta32(value, pvalue)
+		if !ok {
+			reset = true
+		}
+		return d
+	}
+
+	readRequests := delta(v.ReadRequests, pv.ReadRequests)
+	readMerged := delta(v.ReadMerged, pv.ReadMerged)
+	readSectors := delta(v.ReadSectors, pv.ReadSectors)
+	msecRead := msecDelta(v.MsecRead, pv.MsecRead)
+	writeRequests := delta(v.WriteRequests, pv.WriteRequests)
+	writeMerged := delta(v.WriteMerged, pv.WriteMerged)
+	writeSectors := delta(v.WriteSectors, pv.WriteSectors)
+	msecWrite := msecDelta(v.MsecWrite, pv.MsecWrite)
+	msecTotal := msecDelta(v.MsecTotal, pv.MsecTotal)
+	msecWeightedTotal := msecDelta(v.MsecWeightedTotal, pv.MsecWeightedTotal)
+	discardRequests := delta(v.DiscardRequests, pv.DiscardRequests)
+	discardMerged := delta(v.DiscardMerged, pv.DiscardMerged)
+	discardSectors := delta(v.DiscardSectors, pv.DiscardSectors)
+	msecDiscard := msecDelta(v.MsecDiscard, pv.MsecDiscard)
+	flushRequests := delta(v.FlushRequests, pv.FlushRequests)
+	msecFlush := msecDelta(v.MsecFlush, pv.MsecFlush)
+
+	if reset {
+		return
+	}
+
+	interval := uint64(dc.Interval)
+	prefix := dc.host + ".disk." + v.Device
+
+	c <- Gauge(prefix + ".reads.iops").Record(readRequests / interval)
+	c <- Gauge(prefix + ".reads.bytes").Record(readSectors * diskSectorSize / interval)
+	c <- Gauge(prefix + ".reads.merged").Record(readMerged / interval)
+	c <- Gauge(prefix + ".latency.read").Record(await(msecRead, readRequests))
+
+	c <- Gauge(prefix + ".writes.iops").Record(writeRequests / interval)
+	c <- Gauge(prefix + ".writes.bytes").Record(writeSectors * diskSectorSize / interval)
+	c <- Gauge(prefix + ".writes.merged").Record(writeMerged / interval)
+	c <- Gauge(prefix + ".latency.write").Record(await(msecWrite, writeRequests))
+
+	// Share of the interval the device was busy, and the average queue size
+	// times 100 to keep two decimals.
+	util := msecTotal * 100 / (interval * 1000)
+	if util > 100 {
+		util = 100
+	}
+	c <- Gauge(prefix + ".util").Record(util)
+	c <- Gauge(prefix + ".queue_size").Record(msecWeightedTotal * 100 / (interval * 1000))
+	c <- Gauge(prefix + ".in_progress").Record(v.IosInProgress)
+
+	if v.HasDiscard && pv.HasDiscard {
+		c <- Gauge(prefix + ".discards.iops").Record(discardRequests / interval)
+		c <- Gauge(prefix + ".discards.bytes").Record(discardSectors * diskSectorSize / interval)
+		c <- Gauge(prefix + ".discards.merged").Record(discardMerged / interval)
+		c <- Gauge(prefix + ".latency.discard").Record(await(msecDiscard, discardRequests))
+	}
+
+	if v.HasFlush && pv.HasFlush {
+		c <- Gauge(prefix + ".flushes.iops").Record(flushRequests / interval)
+		c <- Gauge(prefix + ".latency.flush").Record(await(msecFlush, flushRequests))
+	}
+}
+
+func (dc *DiskCollector) device(name string) DiskDevice {
+	device, ok := dc.devices[name]
+	if !ok {
+		device = getDiskDevice(name)
+		dc.devices[name] = device
+	}
+	return device
+}
+
+// selectDevices filters the devices to report and names them. Both the
+// kernel and the device mapper names are matched against the patterns. When
+// partitions are aggregated, the partitions of a disk which is not in
+// /proc/diskstats, as xvda1 on some Xen guests, are summed up as that disk,
+// and the other ones are dropped as their disk already accounts for them.
+func (dc *DiskCollector) selectDevices(diskstats map[string]DiskStats) map[string]DiskStats {
+	ret := make(map[string]DiskStats)
+
+	present := make(map[string]bool, len(diskstats))
+	for _, v := range diskstats {
+		present[v.Device] = true
+	}
+
+	for k, v := range diskstats {
+		device := dc.device(v.Device)
+
+		if dc.AggregatePartitions && device.Parent != "" {
+			if present[device.Parent] || !dc.filter.Match(device.Parent) {
+				continue
+			}
+
+			k = "disk:" + device.Parent
+			v.Device = device.Parent
+			if aggregated, ok := ret[k]; ok {
+				v = addDiskStats(aggregated, v)
+			}
+			ret[k] = v
+			continue
+		}
+
+		if !dc.filter.Match(v.Device, device.Name) {
+			continue
+		}
+
+		v.Device = metricPart(device.Name)
+		ret[k] = v
+	}
+
+	// Kernel names are reused, dm-3 may be another volume once recreated
+	for name := range dc.devices {
+		if !present[name] {
+			delete(dc.devices, name)
+		}
+	}
+
+	return ret
+}
+
 func (dc *DiskCollector) Run(c chan *Metric) {
 
 	if !dc.Detect() {
 		return
 	}
 
-	pdiskstats, _ := getDiskStats()
+	pdiskstats, err := getDiskStats()
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		pdiskstats = dc.selectDevices(pdiskstats)
+	}
 
 	for {
 		select {
 		case <-time.After(time.Second * time.Duration(dc.Interval)):
 
-			diskstats, _ := getDiskStats()
+			diskstats, err := getDiskStats()
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
 
-			for k, v := range *diskstats {
-				matched, err := regexp.MatchString("^([a-z]+)$", v.Device)
+			diskstats = dc.selectDevices(diskstats)
 
-				if err != nil || !matched {
+			for k, v := range diskstats {
+				// Nothing is published for the first interval of a device
+				pv, ok := pdiskstats[k]
+				if !ok || pv.Device != v.Device {
 					continue
 				}
 
-				c <- Gauge(dc.host + ".disk." + v.Device + ".writes").Record((v.WriteSectors - (*pdiskstats)[k].WriteSectors) / uint64(dc.Interval))
-				c <- Gauge(dc.host + ".disk." + v.Device + ".reads").Record((v.ReadSectors - (*pdiskstats)[k].ReadSectors) / uint64(dc.Interval))
-				c <- Gauge(dc.host + ".disk." + v.Device + ".iops").Record(v.IosInProgress)
-
-				if v.ReadRequests-(*pdiskstats)[k].ReadRequests != 0 {
-					c <- Gauge(dc.host + ".disk." + v.Device + ".latency.read").Record((v.MsecRead - (*pdiskstats)[k].MsecRead) / (v.ReadRequests - (*pdiskstats)[k].ReadRequests))
-				} else {
-					c <- Gauge(dc.host + ".disk." + v.Device + ".latency.read").Record(0)
-				}
-
-				if v.WriteRequests-(*pdiskstats)[k].WriteRequests != 0 {
-					c <- Gauge(dc.host + ".disk." + v.Device + ".latency.write").Record((v.MsecWrite - (*pdiskstats)[k].MsecWrite) / (v.WriteRequests - (*pdiskstats)[k].WriteRequests))
-				} else {
-					c <- Gauge(dc.host + ".disk." + v.Device + ".latency.write").Record(0)
-				}
+				dc.emit(c, v, pv)
 			}
 			pdiskstats = diskstats
 		}
@@ -0,0 +1,267 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	. "github.com/Searchlight/khronus-go-client"
+	"github.com/mitchellh/mapstructure"
+)
+
+// Pseudo and in memory filesystems skipped when no ExcludeFsTypes are
+// configured.
+var defaultExcludeFsTypes = []string{
+	"^(proc|sysfs|tmpfs|devtmpfs|devpts|overlay|aufs|squashfs|ramfs|rootfs)$",
+	"^(cgroup|cgroup2|pstore|bpf|tracefs|debugfs|securityfs|configfs|fusectl)$",
+	"^(mqueue|hugetlbfs|autofs|binfmt_misc|nsfs|rpc_pipefs|efivarfs|selinuxfs|nfsd)$",
+}
+
+type Mount struct {
+	MountPoint string
+	FsType     string
+	Source     string
+}
+
+// unescapeMount decodes the octal escapes used in mountinfo for spaces,
+// tabs, newlines and backslashes.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+
+	var ret []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				ret = append(ret, byte(c))
+				i += 3
+				continue
+			}
+		}
+		ret = append(ret, s[i])
+	}
+	return string(ret)
+}
+
+// getMounts parses /proc/self/mountinfo. A mount point mounted over keeps
+// only its last mount.
+func getMounts() ([]Mount, error) {
+	mountInfo := procPath("self", "mountinfo")
+
+	contents, err := ioutil.ReadFile(mountInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []Mount
+	index := make(map[string]int)
+
+	reader := bufio.NewReader(bytes.NewBuffer(contents))
+	for {
+		line, _, err := reader.ReadLine()
+		if err == io.EOF {
+			break
+		}
+
+		// id parent major:minor root mountpoint options [optional...] - type source superoptions
+		fields := strings.Fields(string(line))
+
+		separator := -1
+		for k := 6; k < len(fields); k++ {
+			if fields[k] == "-" {
+				separator = k
+				break
+			}
+		}
+
+		if separator < 0 || separator+2 >= len(fields) {
+			continue
+		}
+
+		mount := Mount{
+			MountPoint: unescapeMount(fields[4]),
+			FsType:     fields[separator+1],
+			Source:     unescapeMount(fields[separator+2]),
+		}
+
+		if k, ok := index[mount.MountPoint]; ok {
+			ret[k] = mount
+		} else {
+			index[mount.MountPoint] = len(ret)
+			ret = append(ret, mount)
+		}
+	}
+
+	return ret, nil
+}
+
+type FsCollector struct {
+	host               string
+	Interval           int64
+	Timeout            int64    // Seconds to wait for statfs on a mount
+	IncludeFsTypes     []string // Filesystem type patterns to report, all when empty
+	ExcludeFsTypes     []string // Filesystem type patterns never reported
+	IncludeMountPoints []string // Mount point patterns to report, all when empty
+	ExcludeMountPoints []string // Mount point patterns never reported
+	fsTypes            *NameFilter
+	mountPoints        *NameFilter
+	mutex              sync.Mutex
+	hung               map[string]bool
+}
+
+func (fc *FsCollector) Config(config map[string]interface{}) {
+	fmt.Printf("%s config %#v\n", fc.Name(), fc)
+
+	fc.host, _ = os.Hostname()
+
+	if err := mapstructure.Decode(config, fc); err != nil {
+		panic(err)
+	}
+
+	if fc.Interval == 0 {
+		fc.Interval = 1
+	}
+
+	if fc.Timeout == 0 {
+		fc.Timeout = 5
+	}
+
+	if len(fc.ExcludeFsTypes) == 0 {
+		fc.ExcludeFsTypes = defaultExcludeFsTypes
+	}
+
+	fc.fsTypes = NewNameFilter(fc.IncludeFsTypes, fc.ExcludeFsTypes)
+	fc.mountPoints = NewNameFilter(fc.IncludeMountPoints, fc.ExcludeMountPoints)
+	fc.hung = make(map[string]bool)
+
+	fmt.Printf("%s config %#v\n", fc.Name(), fc)
+}
+
+func (fc *FsCollector) Detect() bool {
+	if runtime.GOOS == "linux" {
+		return true
+	} else {
+		return false
+	}
+}
+
+// statfs gives up on a mount after Timeout, as statfs blocks forever on an
+// unreachable NFS server. The mount is skipped until the pending call
+// returns, so a hung mount holds a single goroutine.
+func (fc *FsCollector) statfs(path string) (*syscall.Statfs_t, error) {
+	fc.mutex.Lock()
+	hung := fc.hung[path]
+	fc.mutex.Unlock()
+
+	if hung {
+		return nil, fmt.Errorf("statfs %s still pending", path)
+	}
+
+	type result struct {
+		stat syscall.Statfs_t
+		err  error
+	}
+
+	done := make(chan result, 1)
+
+	go func() {
+		r := result{}
+		r.err = syscall.Statfs(path, &r.stat)
+		done <- r
+	}()
+
+	select {
+	case r := <-done:
+		return &r.stat, r.err
+	case <-time.After(time.Duration(fc.Timeout) * time.Second):
+		fc.mutex.Lock()
+		fc.hung[path] = true
+		fc.mutex.Unlock()
+
+		go func() {
+			<-done
+			fc.mutex.Lock()
+			delete(fc.hung, path)
+			fc.mutex.Unlock()
+		}()
+
+		return nil, fmt.Errorf("statfs %s timed out", path)
+	}
+}
+
+func (fc *FsCollector) emit(c chan *Metric, mount Mount, stat *syscall.Statfs_t) {
+	name := "root"
+	if mount.MountPoint != "/" {
+		name = metricPart(strings.Trim(mount.MountPoint, "/"))
+	}
+
+	prefix := fc.host + ".fs." + name
+	size := uint64(stat.Bsize)
+
+	total := uint64(stat.Blocks) * size
+	free := uint64(stat.Bfree) * size
+	available := uint64(stat.Bavail) * size
+	used := total - free
+
+	c <- Gauge(prefix + ".total").Record(total)
+	c <- Gauge(prefix + ".used").Record(used)
+	c <- Gauge(prefix + ".free").Record(free)
+	c <- Gauge(prefix + ".available").Record(available)
+	// Like df, the space reserved to root is not counted as usable
+	c <- Gauge(prefix + ".used_percent").Record(percent(used, used+available))
+
+	inodes := uint64(stat.Files)
+	inodesFree := uint64(stat.Ffree)
+
+	// Some filesystems, as btrfs, do not report inodes
+	if inodes > 0 {
+		c <- Gauge(prefix + ".inodes.total").Record(inodes)
+		c <- Gauge(prefix + ".inodes.used").Record(inodes - inodesFree)
+		c <- Gauge(prefix + ".inodes.free").Record(inodesFree)
+		c <- Gauge(prefix + ".inodes.used_percent").Record(percent(inodes-inodesFree, inodes))
+	}
+}
+
+func (fc *FsCollector) Run(c chan *Metric) {
+
+	if !fc.Detect() {
+		return
+	}
+
+	for {
+		mounts, err := getMounts()
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		for _, mount := range mounts {
+			if !fc.fsTypes.Match(mount.FsType) || !fc.mountPoints.Match(mount.MountPoint) {
+				continue
+			}
+
+			stat, err := fc.statfs(mount.MountPoint)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			fc.emit(c, mount, stat)
+		}
+
+		time.Sleep(time.Duration(fc.Interval) * time.Second)
+	}
+}
+
+func (*FsCollector) Name() string {
+	return "linux.fs.stats"
+}
@@ -0,0 +1,127 @@
+package collector
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	. "github.com/Searchlight/khronus-go-client"
+	"github.com/mitchellh/mapstructure"
+)
+
+type KernelLimits struct {
+	FilesAllocated  uint64 // File handles allocated
+	FilesUnused     uint64 // Allocated handles not in use, always 0 since 2.6
+	FilesMax        uint64
+	InodesAllocated uint64
+	InodesFree      uint64
+	Tasks           uint64 // Processes and threads, each holding a pid
+	PidMax          uint64
+	ThreadsMax      uint64
+	Entropy         uint64 // Bits of entropy available
+}
+
+func getKernelLimits() (*KernelLimits, error) {
+	ret := KernelLimits{}
+
+	files, err := readUints(procPath("sys", "fs", "file-nr"), 3)
+	if err != nil {
+		return nil, err
+	}
+	ret.FilesAllocated, ret.FilesUnused, ret.FilesMax = files[0], files[1], files[2]
+
+	inodes, err := readUints(procPath("sys", "fs", "inode-nr"), 2)
+	if err != nil {
+		return nil, err
+	}
+	ret.InodesAllocated, ret.InodesFree = inodes[0], inodes[1]
+
+	load, err := getLoadAverage()
+	if err != nil {
+		return nil, err
+	}
+	ret.Tasks = load.Scheduling
+
+	if ret.PidMax, err = readUint(procPath("sys", "kernel", "pid_max")); err != nil {
+		return nil, err
+	}
+
+	if ret.ThreadsMax, err = readUint(procPath("sys", "kernel", "threads-max")); err != nil {
+		return nil, err
+	}
+
+	if ret.Entropy, err = readUint(procPath("sys", "kernel", "random", "entropy_avail")); err != nil {
+		return nil, err
+	}
+
+	return &ret, nil
+}
+
+type LimitsCollector struct {
+	host     string
+	Interval int64
+}
+
+func (lc *LimitsCollector) Config(config map[string]interface{}) {
+	fmt.Printf("%s config %#v\n", lc.Name(), lc)
+
+	lc.host, _ = os.Hostname()
+
+	if err := mapstructure.Decode(config, lc); err != nil {
+		panic(err)
+	}
+
+	if lc.Interval == 0 {
+		lc.Interval = 1
+	}
+
+	fmt.Printf("%s config %#v\n", lc.Name(), lc)
+}
+
+func (lc *LimitsCollector) Detect() bool {
+	if runtime.GOOS == "linux" {
+		return true
+	} else {
+		return false
+	}
+}
+
+func (lc *LimitsCollector) Run(c chan *Metric) {
+
+	if !lc.Detect() {
+		return
+	}
+
+	for {
+		limits, err := getKernelLimits()
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			files := limits.FilesAllocated - limits.FilesUnused
+
+			c <- Gauge(lc.host + ".limits.files.used").Record(files)
+			c <- Gauge(lc.host + ".limits.files.max").Record(limits.FilesMax)
+			c <- Gauge(lc.host + ".limits.files.used_percent").Record(percent(files, limits.FilesMax))
+
+			c <- Gauge(lc.host + ".limits.inodes.allocated").Record(limits.InodesAllocated)
+			c <- Gauge(lc.host + ".limits.inodes.free").Record(limits.InodesFree)
+
+			c <- Gauge(lc.host + ".limits.pids.used").Record(limits.Tasks)
+			c <- Gauge(lc.host + ".limits.pids.max").Record(limits.PidMax)
+			c <- Gauge(lc.host + ".limits.pids.used_percent").Record(percent(limits.Tasks, limits.PidMax))
+
+			c <- Gauge(lc.host + ".limits.threads.used").Record(limits.Tasks)
+			c <- Gauge(lc.host + ".limits.threads.max").Record(limits.ThreadsMax)
+			c <- Gauge(lc.host + ".limits.threads.used_percent").Record(percent(limits.Tasks, limits.ThreadsMax))
+
+			c <- Gauge(lc.host + ".limits.entropy.available").Record(limits.Entropy)
+		}
+
+		time.Sleep(time.Duration(lc.Interval) * time.Second)
+	}
+}
+
+func (*LimitsCollector) Name() string {
+	return "linux.limits.stats"
+}
@@ -58,10 +58,18 @@ type MemStat struct {
 	VmallocChunk      uint64
 	HardwareCorrupted uint64
 	AnonHugePages     uint64
+	HugePagesTotal    uint64 // Pages in the hugepage pool
+	HugePagesFree     uint64 // Pages of the pool not yet allocated
+	HugePagesRsvd     uint64 // Pages of the pool reserved but not yet faulted in
+	HugePagesSurp     uint64 // Pages above the pool size, up to nr_overcommit_hugepages
+	Hugepagesize      uint64
+	Hugetlb           uint64
 }
 
+// getMem parses /proc/meminfo, values are in kB except for the HugePages_
+// page counts.
 func getMem() (*MemStat, error) {
-	memStats := "/proc/meminfo"
+	memStats := procPath("meminfo")
 	ret := MemStat{}
 
 	if _, err := os.Stat(memStats); err != nil {
@@ -84,16 +92,20 @@ func getMem() (*MemStat, error) {
 
 		fields := strings.Fields(string(line))
 
+		if len(fields) < 2 {
+			continue
+		}
+
 		switch fields[0] {
 		case "Active:":
 			if ret.Active, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
 				return nil, err
 			}
-		case "ActiveAnon:":
+		case "Active(anon):":
 			if ret.ActiveAnon, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
 				return nil, err
 			}
-		case "ActiveFile:":
+		case "Active(file):":
 			if ret.ActiveFile, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
 				return nil, err
 			}
@@ -102,7 +114,7 @@ func getMem() (*MemStat, error) {
 				return nil, err
 			}
 		case "AnonPages:":
-			if ret.AnonHugePages, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+			if ret.AnonPages, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
 				return nil, err
 			}
 		case "Bounce:":
@@ -121,27 +133,51 @@ func getMem() (*MemStat, error) {
 			if ret.CommitLimit, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
 				return nil, err
 			}
-		case "CommittedAs:":
+		case "Committed_AS:":
 			if ret.CommittedAs, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
 				return nil, err
 			}
-		case "Dirtys:":
-			if ret.CommittedAs, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		case "Dirty:":
+			if ret.Dirty, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
 				return nil, err
 			}
 		case "HardwareCorrupted:":
 			if ret.HardwareCorrupted, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
 				return nil, err
 			}
+		case "HugePages_Free:":
+			if ret.HugePagesFree, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return nil, err
+			}
+		case "HugePages_Rsvd:":
+			if ret.HugePagesRsvd, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return nil, err
+			}
+		case "HugePages_Surp:":
+			if ret.HugePagesSurp, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return nil, err
+			}
+		case "HugePages_Total:":
+			if ret.HugePagesTotal, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return nil, err
+			}
+		case "Hugepagesize:":
+			if ret.Hugepagesize, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return nil, err
+			}
+		case "Hugetlb:":
+			if ret.Hugetlb, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return nil, err
+			}
 		case "Inactive:":
 			if ret.Inactive, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
 				return nil, err
 			}
-		case "InactiveAnon:":
+		case "Inactive(anon):":
 			if ret.InactiveAnon, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
 				return nil, err
 			}
-		case "InactiveFile:":
+		case "Inactive(file):":
 			if ret.InactiveFile, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
 				return nil, err
 			}
@@ -245,6 +281,10 @@ func (mc *MemCollector) Config(config map[string]interface{}) {
 		panic(err)
 	}
 
+	if mc.Interval == 0 {
+		mc.Interval = 1
+	}
+
 	fmt.Printf("%s config %#v\n", mc.Name(), mc)
 }
 
@@ -256,6 +296,74 @@ func (mc *MemCollector) Detect() bool {
 	}
 }
 
+// percent returns value as a percentage of total, 0 when total is 0.
+func percent(value uint64, total uint64) uint64 {
+	if total == 0 {
+		return 0
+	}
+	return value * 100 / total
+}
+
+func (mc *MemCollector) emit(c chan *Metric, mem *MemStat) {
+	fields := []struct {
+		name  string
+		value uint64
+	}{
+		{"total", mem.MemTotal},
+		{"free", mem.MemFree},
+		{"available", mem.MemAvailable},
+		{"buffer", mem.Buffers},
+		{"cached", mem.Cached},
+		{"used", mem.MemTotal - mem.MemFree - mem.Cached - mem.Buffers},
+		{"swap_cached", mem.SwapCached},
+		{"active", mem.Active},
+		{"inactive", mem.Inactive},
+		{"active_anon", mem.ActiveAnon},
+		{"inactive_anon", mem.InactiveAnon},
+		{"active_file", mem.ActiveFile},
+		{"inactive_file", mem.InactiveFile},
+		{"unevictable", mem.Unevictable},
+		{"mlocked", mem.Mlocked},
+		{"swap_total", mem.SwapTotal},
+		{"swap_free", mem.SwapFree},
+		{"dirty", mem.Dirty},
+		{"writeback", mem.Writeback},
+		{"anon_pages", mem.AnonPages},
+		{"mapped", mem.Mapped},
+		{"shmem", mem.Shmem},
+		{"slab", mem.Slab},
+		{"slab_reclaimable", mem.SReclaimable},
+		{"slab_unreclaimable", mem.SUnreclaim},
+		{"kernel_stack", mem.KernelStack},
+		{"page_tables", mem.PageTables},
+		{"nfs_unstable", mem.NFS_Unstable},
+		{"bounce", mem.Bounce},
+		{"writeback_tmp", mem.WritebackTmp},
+		{"commit_limit", mem.CommitLimit},
+		{"committed_as", mem.CommittedAs},
+		{"vmalloc_total", mem.VmallocTotal},
+		{"vmalloc_used", mem.VmallocUsed},
+		{"vmalloc_chunk", mem.VmallocChunk},
+		{"hardware_corrupted", mem.HardwareCorrupted},
+		{"anon_huge_pages", mem.AnonHugePages},
+	}
+
+	for _, field := range fields {
+		c <- Gauge(mc.host + ".mem." + field.name).Record(field.value * 1024)
+	}
+
+	// Kernels older than 3.14 have no MemAvailable estimate
+	available := mem.MemAvailable
+	if available == 0 {
+		available = mem.MemFree + mem.Buffers + mem.Cached
+	}
+
+	c <- Gauge(mc.host + ".mem.available_percent").Record(percent(available, mem.MemTotal))
+	c <- Gauge(mc.host + ".mem.swap_used_percent").Record(percent(mem.SwapTotal-mem.SwapFree, mem.SwapTotal))
+	c <- Gauge(mc.host + ".mem.commit_ratio").Record(percent(mem.CommittedAs, mem.CommitLimit))
+	c <- Gauge(mc.host + ".mem.slab_reclaimable_percent").Record(percent(mem.SReclaimable, mem.Slab))
+}
+
 func (mc *MemCollector) Run(c chan *Metric) {
 
 	if !mc.Detect() {
@@ -271,10 +379,9 @@ func (mc *MemCollector) Run(c chan *Metric) {
 
 		select {
 		case <-time.After(time.Second * time.Duration(mc.Interval)):
-			c <- Gauge(mc.host + ".mem.cached").Record(mem.Cached)
-			c <- Gauge(mc.host + ".mem.buffer").Record(mem.Buffers)
-			c <- Gauge(mc.host + ".mem.free").Record(mem.MemFree)
-			c <- Gauge(mc.host + ".mem.used").Record(mem.MemTotal - mem.MemFree - mem.Cached - mem.Buffers)
+			if mem != nil {
+				mc.emit(c, mem)
+			}
 		}
 	}
 }
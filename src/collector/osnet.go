@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -16,20 +17,28 @@ import (
 type NetworkUtilization map[string]DeviceNetworkUtilization
 
 type DeviceNetworkUtilization struct {
-	RxBytes          int64
-	RxPackets        int64
-	RxErrors         int64
-	RxDroppedPackets int64
-	TxBytes          int64
-	TxPackets        int64
-	TxErrors         int64
-	TxDroppedPackets int64
+	RxBytes          uint64
+	RxPackets        uint64
+	RxErrors         uint64
+	RxDroppedPackets uint64
+	RxFifo           uint64 // Receive FIFO buffer errors
+	RxFrame          uint64 // Packet framing errors
+	RxCompressed     uint64
+	RxMulticast      uint64
+	TxBytes          uint64
+	TxPackets        uint64
+	TxErrors         uint64
+	TxDroppedPackets uint64
+	TxFifo           uint64 // Transmit FIFO buffer errors
+	TxCollisions     uint64
+	TxCarrier        uint64 // Carrier losses
+	TxCompressed     uint64
 }
 
-func getNetStats() (*map[string]DeviceNetworkUtilization, error) {
-	ret := make(map[string]DeviceNetworkUtilization)
+func getNetStats() (NetworkUtilization, error) {
+	ret := make(NetworkUtilization)
 
-	statFile, err := ioutil.ReadFile("/proc/net/dev")
+	statFile, err := ioutil.ReadFile(procPath("net", "dev"))
 
 	if err != nil {
 		return nil, err
@@ -46,43 +55,80 @@ func getNetStats() (*map[string]DeviceNetworkUtilization, error) {
 		if len(strings.TrimSpace(line)) == 0 {
 			continue
 		}
-		fields := strings.Fields(line)
+
+		// Large byte counters may be glued to the interface name
+		sep := strings.LastIndex(line, ":")
+		if sep < 0 {
+			return nil, fmt.Errorf("/proc/net/dev doesn't have the expected format. Missing interface name")
+		}
+		name := strings.TrimSpace(line[:sep])
+		fields := strings.Fields(line[sep+1:])
 		if len(fields) < 16 {
 			return nil, fmt.Errorf("/proc/net/dev doesn't have the expected format. Expected 16 fields found %d", len(fields))
 		}
-		name := strings.Trim(fields[0], ":")
-		if utilization.RxBytes, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
-			return nil, err
-		}
-		if utilization.RxPackets, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
-			return nil, err
-		}
-		if utilization.RxErrors, err = strconv.ParseInt(fields[3], 10, 64); err != nil {
-			return nil, err
-		}
-		if utilization.RxDroppedPackets, err = strconv.ParseInt(fields[4], 10, 64); err != nil {
-			return nil, err
-		}
-		if utilization.TxBytes, err = strconv.ParseInt(fields[9], 10, 64); err != nil {
-			return nil, err
-		}
-		if utilization.TxPackets, err = strconv.ParseInt(fields[10], 10, 64); err != nil {
-			return nil, err
-		}
-		if utilization.TxErrors, err = strconv.ParseInt(fields[11], 10, 64); err != nil {
-			return nil, err
+
+		columns := []*uint64{
+			&utilization.RxBytes, &utilization.RxPackets, &utilization.RxErrors, &utilization.RxDroppedPackets,
+			&utilization.RxFifo, &utilization.RxFrame, &utilization.RxCompressed, &utilization.RxMulticast,
+			&utilization.TxBytes, &utilization.TxPackets, &utilization.TxErrors, &utilization.TxDroppedPackets,
+			&utilization.TxFifo, &utilization.TxCollisions, &utilization.TxCarrier, &utilization.TxCompressed,
 		}
-		if utilization.TxDroppedPackets, err = strconv.ParseInt(fields[12], 10, 64); err != nil {
-			return nil, err
+
+		for k, column := range columns {
+			if *column, err = strconv.ParseUint(fields[k], 10, 64); err != nil {
+				return nil, err
+			}
 		}
+
 		ret[name] = utilization
 	}
-	return &ret, nil
+	return ret, nil
+}
+
+// Interfaces skipped when no Exclude patterns are configured, the loopback
+// and the host side of container veth pairs.
+var defaultNetExclude = []string{"^lo$", "^veth"}
+
+type NetInterface struct {
+	Speed     int64  // Link speed in Mb/s, -1 when unknown or the link is down
+	OperState string // up, down, dormant, lowerlayerdown or unknown
+	Mtu       uint64
+	Master    string // Bond or bridge enslaving the interface
+}
+
+// Up reports whether the link is operational. Virtual interfaces, as the
+// loopback or tunnels, report an unknown state.
+func (i NetInterface) Up() bool {
+	return i.OperState == "up" || i.OperState == "unknown"
+}
+
+func getNetInterface(name string) NetInterface {
+	ret := NetInterface{Speed: -1}
+
+	// Reading the speed fails with EINVAL while the link is down
+	if speed, err := readString(sysPath("class", "net", name, "speed")); err == nil {
+		if ret.Speed, err = strconv.ParseInt(speed, 10, 64); err != nil {
+			ret.Speed = -1
+		}
+	}
+
+	ret.OperState, _ = readString(sysPath("class", "net", name, "operstate"))
+	ret.Mtu, _ = readUint(sysPath("class", "net", name, "mtu"))
+
+	if master, err := os.Readlink(sysPath("class", "net", name, "master")); err == nil {
+		ret.Master = filepath.Base(master)
+	}
+
+	return ret
 }
 
 type NetCollector struct {
 	host     string
 	Interval int64
+	Include  []string // Interface name patterns to report, all when empty
+	Exclude  []string // Interface name patterns never reported
+	Metadata bool     // Report link speed, state, mtu and bond or bridge rollups from sysfs
+	filter   *NameFilter
 }
 
 func (nc *NetCollector) Config(config map[string]interface{}) {
@@ -94,6 +140,16 @@ func (nc *NetCollector) Config(config map[string]interface{}) {
 		panic(err)
 	}
 
+	if nc.Interval == 0 {
+		nc.Interval = 1
+	}
+
+	if len(nc.Exclude) == 0 {
+		nc.Exclude = defaultNetExclude
+	}
+
+	nc.filter = NewNameFilter(nc.Include, nc.Exclude)
+
 	fmt.Printf("%s config %#v\n", nc.Name(), nc)
 }
 
@@ -105,28 +161,149 @@ func (nc *NetCollector) Detect() bool {
 	}
 }
 
+// emit publishes the per second activity of an interface between two reads,
+// nothing is published when one of its counters was reset, as when a driver
+// is reloaded.
+func (nc *NetCollector) emit(c chan *Metric, name string, v DeviceNetworkUtilization, pv DeviceNetworkUtilization, link *NetInterface) {
+	counters := []struct {
+		name   string
+		value  uint64
+		pvalue uint64
+	}{
+		{"reads.bytes", v.RxBytes, pv.RxBytes},
+		{"reads.packets", v.RxPackets, pv.RxPackets},
+		{"reads.errors", v.RxErrors, pv.RxErrors},
+		{"reads.drops", v.RxDroppedPackets, pv.RxDroppedPackets},
+		{"reads.fifo", v.RxFifo, pv.RxFifo},
+		{"reads.frame", v.RxFrame, pv.RxFrame},
+		{"reads.compressed", v.RxCompressed, pv.RxCompressed},
+		{"reads.multicast", v.RxMulticast, pv.RxMulticast},
+		{"writes.bytes", v.TxBytes, pv.TxBytes},
+		{"writes.packets", v.TxPackets, pv.TxPackets},
+		{"writes.errors", v.TxErrors, pv.TxErrors},
+		{"writes.drops", v.TxDroppedPackets, pv.TxDroppedPackets},
+		{"writes.fifo", v.TxFifo, pv.TxFifo},
+		{"writes.collisions", v.TxCollisions, pv.TxCollisions},
+		{"writes.carrier", v.TxCarrier, pv.TxCarrier},
+		{"writes.compressed", v.TxCompressed, pv.TxCompressed},
+	}
+
+	deltas := make([]uint64, len(counters))
+	for k, counter := range counters {
+		delta, ok := counterDelta(counter.value, counter.pvalue)
+		if !ok {
+			return
+		}
+		deltas[k] = delta
+	}
+
+	prefix := nc.host + ".net." + metricPart(name)
+
+	for k, counter := range counters {
+		c <- Gauge(prefix + "." + counter.name).Record(deltas[k] / uint64(nc.Interval))
+	}
+
+	if link == nil || link.Speed <= 0 {
+		return
+	}
+
+	// Share of the link speed used in each direction
+	capacity := uint64(link.Speed) * 1000000 * uint64(nc.Interval)
+	c <- Gauge(prefix + ".reads.utilization").Record(deltas[0] * 8 * 100 / capacity)
+	c <- Gauge(prefix + ".writes.utilization").Record(deltas[8] * 8 * 100 / capacity)
+}
+
+func (nc *NetCollector) emitLink(c chan *Metric, name string, link NetInterface) {
+	prefix := nc.host + ".net." + metricPart(name)
+
+	up := uint64(0)
+	if link.Up() {
+		up = 1
+	}
+
+	c <- Gauge(prefix + ".up").Record(up)
+	c <- Gauge(prefix + ".mtu").Record(link.Mtu)
+
+	if link.Speed > 0 {
+		c <- Gauge(prefix + ".speed").Record(uint64(link.Speed))
+	}
+}
+
+// emitMasters publishes how many of the interfaces enslaved to each bond or
+// bridge are up.
+func (nc *NetCollector) emitMasters(c chan *Metric, links map[string]NetInterface) {
+	total := make(map[string]uint64)
+	up := make(map[string]uint64)
+
+	for _, link := range links {
+		if link.Master == "" {
+			continue
+		}
+
+		total[link.Master]++
+		if link.Up() {
+			up[link.Master]++
+		}
+	}
+
+	for master := range total {
+		c <- Gauge(nc.host + ".net." + metricPart(master) + ".slaves.total").Record(total[master])
+		c <- Gauge(nc.host + ".net." + metricPart(master) + ".slaves.up").Record(up[master])
+	}
+}
+
 func (nc *NetCollector) Run(c chan *Metric) {
 
 	if !nc.Detect() {
 		return
 	}
 
+	pnetstats, err := getNetStats()
+	if err != nil {
+		fmt.Println(err)
+	}
+
 	for {
-		pnetstats, _ := getNetStats()
 		time.Sleep(time.Second * time.Duration(nc.Interval))
-		netstats, _ := getNetStats()
-
-		for k, v := range *netstats {
-			c <- Gauge(nc.host + ".net." + k + ".reads.packets").Record(uint64((v.RxPackets - (*pnetstats)[k].RxPackets) / nc.Interval))
-			c <- Gauge(nc.host + ".net." + k + ".reads.bytes").Record(uint64((v.RxBytes - (*pnetstats)[k].RxBytes) / nc.Interval))
-			c <- Gauge(nc.host + ".net." + k + ".writes.packets").Record(uint64((v.TxPackets - (*pnetstats)[k].TxPackets) / nc.Interval))
-			c <- Gauge(nc.host + ".net." + k + ".writes.bytes").Record(uint64((v.TxBytes - (*pnetstats)[k].TxBytes) / nc.Interval))
-			c <- Gauge(nc.host + ".net." + k + ".errors.packets").Record(uint64((v.RxErrors + v.TxErrors) - ((*pnetstats)[k].RxErrors + (*pnetstats)[k].TxErrors)))
-			c <- Gauge(nc.host + ".net." + k + ".drops.packets").Record(uint64((v.RxDroppedPackets + v.TxDroppedPackets) - ((*pnetstats)[k].RxDroppedPackets + (*pnetstats)[k].TxDroppedPackets)))
+
+		netstats, err := getNetStats()
+		if err != nil {
+			fmt.Println(err)
+			continue
 		}
+
+		links := make(map[string]NetInterface)
+
+		for k, v := range netstats {
+			if !nc.filter.Match(k) {
+				continue
+			}
+
+			var link *NetInterface
+			if nc.Metadata {
+				l := getNetInterface(k)
+				nc.emitLink(c, k, l)
+				links[k] = l
+				link = &l
+			}
+
+			// Nothing is published for the first interval of an interface
+			pv, ok := pnetstats[k]
+			if !ok {
+				continue
+			}
+
+			nc.emit(c, k, v, pv, link)
+		}
+
+		if nc.Metadata {
+			nc.emitMasters(c, links)
+		}
+
+		pnetstats = netstats
 	}
 }
 
 func (*NetCollector) Name() string {
-	return "linux.disk.stats"
+	return "linux.net.stats"
 }
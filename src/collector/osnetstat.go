@@ -0,0 +1,205 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/Searchlight/khronus-go-client"
+	"github.com/mitchellh/mapstructure"
+)
+
+// Protocol counters emitted as per second rates, keyed as returned by
+// getNetProtoStats.
+var netstatCounters = []struct {
+	key  string
+	name string
+}{
+	{"Tcp.ActiveOpens", "tcp.active_opens"},
+	{"Tcp.PassiveOpens", "tcp.passive_opens"},
+	{"Tcp.RetransSegs", "tcp.retrans_segs"},
+	{"Tcp.InErrs", "tcp.in_errs"},
+	{"Tcp.OutRsts", "tcp.out_rsts"},
+	{"TcpExt.ListenOverflows", "tcp.listen_overflows"},
+	{"TcpExt.ListenDrops", "tcp.listen_drops"},
+	{"TcpExt.TCPTimeouts", "tcp.timeouts"},
+	{"Udp.InErrors", "udp.in_errors"},
+	{"Udp.RcvbufErrors", "udp.rcvbuf_errors"},
+	{"Udp.SndbufErrors", "udp.sndbuf_errors"},
+	{"Udp6.InErrors", "udp6.in_errors"},
+	{"Udp6.RcvbufErrors", "udp6.rcvbuf_errors"},
+	{"Udp6.SndbufErrors", "udp6.sndbuf_errors"},
+}
+
+// parseSnmp parses the pairs of header and value lines of /proc/net/snmp
+// and /proc/net/netstat, as "Tcp: RtoAlgorithm RtoMin" then "Tcp: 1 200".
+func parseSnmp(path string, ret map[string]uint64) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var header []string
+
+	reader := bufio.NewReader(bytes.NewBuffer(contents))
+	for {
+		line, _, err := reader.ReadLine()
+		if err == io.EOF {
+			break
+		}
+
+		fields := strings.Fields(string(line))
+		if len(fields) < 2 {
+			continue
+		}
+
+		if header == nil || header[0] != fields[0] {
+			header = fields
+			continue
+		}
+
+		proto := strings.TrimSuffix(fields[0], ":")
+		for k := 1; k < len(fields) && k < len(header); k++ {
+			// Tcp MaxConn is -1 when the limit is dynamic
+			if value, err := strconv.ParseUint(fields[k], 10, 64); err == nil {
+				ret[proto+"."+header[k]] = value
+			}
+		}
+		header = nil
+	}
+
+	return nil
+}
+
+// parseSnmp6 parses the "Udp6InErrors 0" lines of /proc/net/snmp6, keyed
+// as "Udp6.InErrors".
+func parseSnmp6(path string, ret map[string]uint64) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(bytes.NewBuffer(contents))
+	for {
+		line, _, err := reader.ReadLine()
+		if err == io.EOF {
+			break
+		}
+
+		fields := strings.Fields(string(line))
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if i := strings.Index(fields[0], "6"); i > 0 {
+			ret[fields[0][:i+1]+"."+fields[0][i+1:]] = value
+		}
+	}
+
+	return nil
+}
+
+// getNetProtoStats merges the ip, tcp and udp counters of the kernel. The
+// IPv6 ones are missing when IPv6 is disabled.
+func getNetProtoStats() (map[string]uint64, error) {
+	ret := make(map[string]uint64)
+
+	if err := parseSnmp(procPath("net", "snmp"), ret); err != nil {
+		return nil, err
+	}
+
+	if err := parseSnmp(procPath("net", "netstat"), ret); err != nil {
+		return nil, err
+	}
+
+	if err := parseSnmp6(procPath("net", "snmp6"), ret); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+type NetstatCollector struct {
+	host     string
+	Interval int64
+}
+
+func (nc *NetstatCollector) Config(config map[string]interface{}) {
+	fmt.Printf("%s config %#v\n", nc.Name(), nc)
+
+	nc.host, _ = os.Hostname()
+
+	if err := mapstructure.Decode(config, nc); err != nil {
+		panic(err)
+	}
+
+	if nc.Interval == 0 {
+		nc.Interval = 1
+	}
+
+	fmt.Printf("%s config %#v\n", nc.Name(), nc)
+}
+
+func (nc *NetstatCollector) Detect() bool {
+	if runtime.GOOS == "linux" {
+		return true
+	} else {
+		return false
+	}
+}
+
+func (nc *NetstatCollector) Run(c chan *Metric) {
+
+	if !nc.Detect() {
+		return
+	}
+
+	pstats, err := getNetProtoStats()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	for {
+		time.Sleep(time.Duration(nc.Interval) * time.Second)
+
+		stats, err := getNetProtoStats()
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		if established, ok := stats["Tcp.CurrEstab"]; ok {
+			c <- Gauge(nc.host + ".netstat.tcp.curr_estab").Record(established)
+		}
+
+		for _, counter := range netstatCounters {
+			value, ok := stats[counter.key]
+			pvalue, pok := pstats[counter.key]
+			if !ok || !pok {
+				continue
+			}
+
+			if delta, ok := counterDelta(value, pvalue); ok {
+				c <- Gauge(nc.host + ".netstat." + counter.name).Record(delta / uint64(nc.Interval))
+			}
+		}
+
+		pstats = stats
+	}
+}
+
+func (*NetstatCollector) Name() string {
+	return "linux.netstat.stats"
+}
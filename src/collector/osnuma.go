@@ -0,0 +1,186 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/Searchlight/khronus-go-client"
+	"github.com/mitchellh/mapstructure"
+)
+
+// numastat counters emitted as per second rates.
+var numastatKeys = []string{"numa_hit", "numa_miss", "numa_foreign", "interleave_hit", "local_node", "other_node"}
+
+type NumaNode struct {
+	Node     string            // Node number
+	Meminfo  map[string]uint64 // meminfo fields of the node, in kB except for HugePages_ counts
+	Numastat map[string]uint64 // Allocation counters of the node
+}
+
+// parseNodeFile parses the "key value" lines of a node numastat file, and
+// the "Node N key: value [kB]" lines of a node meminfo file.
+func parseNodeFile(path string) (map[string]uint64, error) {
+	ret := make(map[string]uint64)
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(bytes.NewBuffer(contents))
+	for {
+		line, _, err := reader.ReadLine()
+		if err == io.EOF {
+			break
+		}
+
+		fields := strings.Fields(string(line))
+		if len(fields) >= 4 && fields[0] == "Node" {
+			fields = fields[2:]
+		}
+		if len(fields) < 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ret[strings.TrimSuffix(fields[0], ":")] = value
+	}
+
+	return ret, nil
+}
+
+func getNumaNodes() ([]NumaNode, error) {
+	dirs, err := filepath.Glob(sysPath("devices", "system", "node", "node[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []NumaNode
+
+	for _, dir := range dirs {
+		node := NumaNode{Node: strings.TrimPrefix(filepath.Base(dir), "node")}
+
+		if node.Meminfo, err = parseNodeFile(filepath.Join(dir, "meminfo")); err != nil {
+			return nil, err
+		}
+		if node.Numastat, err = parseNodeFile(filepath.Join(dir, "numastat")); err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, node)
+	}
+
+	return ret, nil
+}
+
+type NumaCollector struct {
+	host     string
+	Interval int64
+}
+
+func (nc *NumaCollector) Config(config map[string]interface{}) {
+	fmt.Printf("%s config %#v\n", nc.Name(), nc)
+
+	nc.host, _ = os.Hostname()
+
+	if err := mapstructure.Decode(config, nc); err != nil {
+		panic(err)
+	}
+
+	if nc.Interval == 0 {
+		nc.Interval = 1
+	}
+
+	fmt.Printf("%s config %#v\n", nc.Name(), nc)
+}
+
+func (nc *NumaCollector) Detect() bool {
+	if runtime.GOOS == "linux" {
+		return true
+	} else {
+		return false
+	}
+}
+
+func (nc *NumaCollector) emitNode(c chan *Metric, node NumaNode, pnode *NumaNode) {
+	prefix := nc.host + ".numa.node" + node.Node
+
+	total, free := node.Meminfo["MemTotal"], node.Meminfo["MemFree"]
+
+	c <- Gauge(prefix + ".mem.total").Record(total * 1024)
+	c <- Gauge(prefix + ".mem.free").Record(free * 1024)
+	c <- Gauge(prefix + ".mem.used").Record((total - free) * 1024)
+	c <- Gauge(prefix + ".hugepages.total").Record(node.Meminfo["HugePages_Total"])
+	c <- Gauge(prefix + ".hugepages.free").Record(node.Meminfo["HugePages_Free"])
+	c <- Gauge(prefix + ".hugepages.surplus").Record(node.Meminfo["HugePages_Surp"])
+
+	if pnode == nil {
+		return
+	}
+
+	for _, key := range numastatKeys {
+		value, ok := node.Numastat[key]
+		pvalue, pok := pnode.Numastat[key]
+		if !ok || !pok || value < pvalue {
+			continue
+		}
+
+		c <- Gauge(prefix + "." + key).Record((value - pvalue) / uint64(nc.Interval))
+	}
+}
+
+func (nc *NumaCollector) Run(c chan *Metric) {
+
+	if !nc.Detect() {
+		return
+	}
+
+	previous := make(map[string]NumaNode)
+
+	for {
+		mem, err := getMem()
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			c <- Gauge(nc.host + ".hugepages.total").Record(mem.HugePagesTotal)
+			c <- Gauge(nc.host + ".hugepages.free").Record(mem.HugePagesFree)
+			c <- Gauge(nc.host + ".hugepages.reserved").Record(mem.HugePagesRsvd)
+			c <- Gauge(nc.host + ".hugepages.surplus").Record(mem.HugePagesSurp)
+			c <- Gauge(nc.host + ".hugepages.size").Record(mem.Hugepagesize * 1024)
+			c <- Gauge(nc.host + ".hugepages.hugetlb").Record(mem.Hugetlb * 1024)
+		}
+
+		// Kernels built without NUMA support have no node directories
+		nodes, err := getNumaNodes()
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		for _, node := range nodes {
+			if pnode, ok := previous[node.Node]; ok {
+				nc.emitNode(c, node, &pnode)
+			} else {
+				nc.emitNode(c, node, nil)
+			}
+			previous[node.Node] = node
+		}
+
+		time.Sleep(time.Duration(nc.Interval) * time.Second)
+	}
+}
+
+func (*NumaCollector) Name() string {
+	return "linux.numa.stats"
+}
@@ -0,0 +1,427 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/Searchlight/khronus-go-client"
+	"github.com/mitchellh/mapstructure"
+)
+
+// Clock ticks per second of the cpu times in /proc/[pid]/stat, USER_HZ is
+// 100 on every architecture Linux exposes to user space.
+const userHz = 100
+
+type ProcStat struct {
+	Pid        int
+	Comm       string // Executable name, truncated to 15 characters
+	State      string // R running, S sleeping, D disk sleep, Z zombie, T stopped...
+	Ppid       int
+	Utime      uint64 // Ticks scheduled in user mode
+	Stime      uint64 // Ticks scheduled in kernel mode
+	NumThreads uint64
+	StartTime  uint64 // Ticks since boot when the process started
+	Rss        uint64 // Resident pages
+}
+
+type ProcIO struct {
+	ReadBytes  uint64 // Bytes fetched from the storage layer
+	WriteBytes uint64 // Bytes sent to the storage layer
+}
+
+// getPids lists the processes in /proc.
+func getPids() ([]int, error) {
+	dir, err := os.Open(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []int
+	for _, name := range names {
+		if pid, err := strconv.Atoi(name); err == nil {
+			ret = append(ret, pid)
+		}
+	}
+	return ret, nil
+}
+
+func getProcStat(pid int) (*ProcStat, error) {
+	contents, err := ioutil.ReadFile(procPath(strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+
+	// The executable name may hold spaces and parenthesis
+	start := bytes.IndexByte(contents, '(')
+	end := bytes.LastIndexByte(contents, ')')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("/proc/%d/stat doesn't have the expected format", pid)
+	}
+
+	fields := strings.Fields(string(contents[end+1:]))
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("/proc/%d/stat doesn't have the expected format. Expected 24 fields found %d", pid, len(fields)+2)
+	}
+
+	ret := ProcStat{
+		Pid:   pid,
+		Comm:  string(contents[start+1 : end]),
+		State: fields[0],
+	}
+
+	if ret.Ppid, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, err
+	}
+	if ret.Utime, err = strconv.ParseUint(fields[11], 10, 64); err != nil {
+		return nil, err
+	}
+	if ret.Stime, err = strconv.ParseUint(fields[12], 10, 64); err != nil {
+		return nil, err
+	}
+	if ret.NumThreads, err = strconv.ParseUint(fields[17], 10, 64); err != nil {
+		return nil, err
+	}
+	if ret.StartTime, err = strconv.ParseUint(fields[19], 10, 64); err != nil {
+		return nil, err
+	}
+	if ret.Rss, err = strconv.ParseUint(fields[21], 10, 64); err != nil {
+		return nil, err
+	}
+
+	return &ret, nil
+}
+
+// getProcIO reads the storage I/O of a process, only readable by its owner.
+func getProcIO(pid int) (*ProcIO, error) {
+	contents, err := ioutil.ReadFile(procPath(strconv.Itoa(pid), "io"))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := ProcIO{}
+
+	reader := bufio.NewReader(bytes.NewBuffer(contents))
+	for {
+		line, _, err := reader.ReadLine()
+		if err == io.EOF {
+			break
+		}
+
+		fields := strings.Fields(string(line))
+		if len(fields) != 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "read_bytes:":
+			if ret.ReadBytes, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return nil, err
+			}
+		case "write_bytes:":
+			if ret.WriteBytes, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &ret, nil
+}
+
+// countProcFds counts the open file descriptors of a process, only readable
+// by its owner.
+func countProcFds(pid int) (uint64, error) {
+	dir, err := os.Open(procPath(strconv.Itoa(pid), "fd"))
+	if err != nil {
+		return 0, err
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	return uint64(len(names)), err
+}
+
+func getProcCmdline(pid int) (string, error) {
+	contents, err := ioutil.ReadFile(procPath(strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bytes.Replace(contents, []byte{0}, []byte{' '}, -1))), nil
+}
+
+// getProcCgroups returns the cgroup paths of a process, one per hierarchy.
+func getProcCgroups(pid int) ([]string, error) {
+	contents, err := ioutil.ReadFile(procPath(strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []string
+	for _, line := range strings.Split(string(contents), "\n") {
+		// hierarchy-id:controllers:path
+		if parts := strings.SplitN(line, ":", 3); len(parts) == 3 {
+			ret = append(ret, parts[2])
+		}
+	}
+	return ret, nil
+}
+
+type ProcessGroup struct {
+	Name    string // Pattern matched against the executable name
+	Cmdline string // Pattern matched against the command line
+	Pidfile string // File holding the pid of the process
+	Unit    string // systemd unit running the processes, as nginx.service
+}
+
+type processMatcher struct {
+	name    *regexp.Regexp
+	cmdline *regexp.Regexp
+	pidfile string
+	pid     string // Pid read from the pidfile on the current scan
+	unit    string
+}
+
+// match reports whether a process meets every criteria set in its group.
+func (m *processMatcher) match(stat *ProcStat) bool {
+	if m.name != nil && !m.name.MatchString(stat.Comm) {
+		return false
+	}
+
+	if m.pidfile != "" && m.pid != strconv.Itoa(stat.Pid) {
+		return false
+	}
+
+	if m.cmdline != nil {
+		cmdline, err := getProcCmdline(stat.Pid)
+		if err != nil || !m.cmdline.MatchString(cmdline) {
+			return false
+		}
+	}
+
+	if m.unit != "" {
+		cgroups, err := getProcCgroups(stat.Pid)
+		if err != nil {
+			return false
+		}
+
+		found := false
+		for _, cgroup := range cgroups {
+			if strings.HasSuffix(cgroup, "/"+m.unit) || strings.Contains(cgroup, "/"+m.unit+"/") {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// processSample is what is kept of a process to compute its rates on the
+// next scan.
+type processSample struct {
+	Utime      uint64
+	Stime      uint64
+	ReadBytes  uint64
+	WriteBytes uint64
+	HasIO      bool
+}
+
+// processGroupStats accumulates the processes of a group during a scan.
+type processGroupStats struct {
+	Count      uint64
+	Threads    uint64
+	Rss        uint64
+	Fds        uint64
+	Utime      uint64 // Ticks of the processes already known on the previous scan
+	Stime      uint64
+	ReadBytes  uint64
+	WriteBytes uint64
+}
+
+type ProcessCollector struct {
+	host     string
+	Interval int64
+	Groups   map[string]ProcessGroup // Processes to report, by group name
+	matchers map[string]*processMatcher
+	previous map[string]processSample
+}
+
+func (pc *ProcessCollector) Config(config map[string]interface{}) {
+	fmt.Printf("%s config %#v\n", pc.Name(), pc)
+
+	pc.host, _ = os.Hostname()
+
+	if err := mapstructure.Decode(config, pc); err != nil {
+		panic(err)
+	}
+
+	if pc.Interval == 0 {
+		pc.Interval = 1
+	}
+
+	pc.matchers = make(map[string]*processMatcher)
+
+	for name, group := range pc.Groups {
+		m := &processMatcher{pidfile: group.Pidfile, unit: group.Unit}
+
+		if group.Name != "" {
+			m.name = regexp.MustCompile(group.Name)
+		}
+		if group.Cmdline != "" {
+			m.cmdline = regexp.MustCompile(group.Cmdline)
+		}
+
+		pc.matchers[name] = m
+	}
+
+	pc.previous = make(map[string]processSample)
+
+	fmt.Printf("%s config %#v\n", pc.Name(), pc)
+}
+
+func (pc *ProcessCollector) Detect() bool {
+	if runtime.GOOS == "linux" {
+		return true
+	} else {
+		return false
+	}
+}
+
+// scan aggregates the processes of every group. Processes are identified by
+// pid and start time, so a recycled pid is not mistaken for the process
+// previously holding it.
+func (pc *ProcessCollector) scan() (map[string]*processGroupStats, error) {
+	pids, err := getPids()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*processGroupStats)
+	for name, m := range pc.matchers {
+		groups[name] = &processGroupStats{}
+
+		if m.pidfile != "" {
+			// A missing pidfile matches no process
+			m.pid, _ = readString(m.pidfile)
+		}
+	}
+
+	current := make(map[string]processSample)
+
+	for _, pid := range pids {
+		// The process may have exited since the listing
+		stat, err := getProcStat(pid)
+		if err != nil {
+			continue
+		}
+
+		var matched []*processGroupStats
+		for name, m := range pc.matchers {
+			if m.match(stat) {
+				matched = append(matched, groups[name])
+			}
+		}
+
+		if len(matched) == 0 {
+			continue
+		}
+
+		key := strconv.Itoa(pid) + ":" + strconv.FormatUint(stat.StartTime, 10)
+		sample := processSample{Utime: stat.Utime, Stime: stat.Stime}
+
+		if io, err := getProcIO(pid); err == nil {
+			sample.ReadBytes, sample.WriteBytes, sample.HasIO = io.ReadBytes, io.WriteBytes, true
+		}
+
+		fds, _ := countProcFds(pid)
+		psample, known := pc.previous[key]
+
+		for _, group := range matched {
+			group.Count++
+			group.Threads += stat.NumThreads
+			group.Rss += stat.Rss * uint64(os.Getpagesize())
+			group.Fds += fds
+
+			if known {
+				group.Utime += sample.Utime - psample.Utime
+				group.Stime += sample.Stime - psample.Stime
+
+				if sample.HasIO && psample.HasIO {
+					group.ReadBytes += sample.ReadBytes - psample.ReadBytes
+					group.WriteBytes += sample.WriteBytes - psample.WriteBytes
+				}
+			}
+		}
+
+		current[key] = sample
+	}
+
+	pc.previous = current
+
+	return groups, nil
+}
+
+func (pc *ProcessCollector) Run(c chan *Metric) {
+
+	if !pc.Detect() {
+		return
+	}
+
+	if len(pc.Groups) == 0 {
+		fmt.Printf("%s has no process groups configured\n", pc.Name())
+		return
+	}
+
+	// The first scan only records the cpu and I/O counters
+	if _, err := pc.scan(); err != nil {
+		fmt.Println(err)
+	}
+
+	for {
+		time.Sleep(time.Duration(pc.Interval) * time.Second)
+
+		groups, err := pc.scan()
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		ticks := float64(userHz * pc.Interval)
+
+		for name, group := range groups {
+			prefix := pc.host + ".process." + metricPart(name)
+
+			c <- Gauge(prefix + ".count").Record(group.Count)
+			c <- Gauge(prefix + ".threads").Record(group.Threads)
+			c <- Gauge(prefix + ".rss").Record(group.Rss)
+			c <- Gauge(prefix + ".fds").Record(group.Fds)
+			c <- Gauge(prefix + ".cpu.user").Record(uint64(float64(group.Utime) * 100 / ticks))
+			c <- Gauge(prefix + ".cpu.sys").Record(uint64(float64(group.Stime) * 100 / ticks))
+			c <- Gauge(prefix + ".cpu.total").Record(uint64(float64(group.Utime+group.Stime) * 100 / ticks))
+			c <- Gauge(prefix + ".io.read_bytes").Record(group.ReadBytes / uint64(pc.Interval))
+			c <- Gauge(prefix + ".io.write_bytes").Record(group.WriteBytes / uint64(pc.Interval))
+		}
+	}
+}
+
+func (*ProcessCollector) Name() string {
+	return "linux.process.stats"
+}
@@ -0,0 +1,184 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+
+	. "github.com/Searchlight/khronus-go-client"
+	"github.com/mitchellh/mapstructure"
+)
+
+// Process states as shown in /proc/[pid]/stat, the ones not listed are
+// reported as other.
+var procStateNames = map[string]string{
+	"R": "running",
+	"S": "sleeping",
+	"D": "disk_sleep",
+	"Z": "zombie",
+	"T": "stopped",
+	"t": "tracing_stop",
+	"I": "idle",
+	"X": "dead",
+}
+
+type ProcStateSummary struct {
+	Total   uint64
+	Threads uint64
+	States  map[string]uint64 // Processes by state name
+}
+
+// readProcState reads only the state and thread count of a process, the
+// summary doesn't need the other /proc/[pid]/stat fields. buf is reused
+// across processes and must hold the whole file.
+func readProcState(pid int, buf []byte) (byte, uint64, error) {
+	file, err := os.Open(procPath(strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return 0, 0, err
+	}
+	contents := buf[:n]
+
+	// The executable name may hold spaces and parenthesis, the fields
+	// start after the last one
+	end := bytes.LastIndexByte(contents, ')')
+	if end < 0 || end+2 >= len(contents) {
+		return 0, 0, fmt.Errorf("/proc/%d/stat doesn't have the expected format", pid)
+	}
+	fields := contents[end+2:]
+	state := fields[0]
+
+	// num_threads is the 17th field after the state
+	for i := 0; i < 17; i++ {
+		j := bytes.IndexByte(fields, ' ')
+		if j < 0 {
+			return 0, 0, fmt.Errorf("/proc/%d/stat doesn't have the expected format", pid)
+		}
+		fields = fields[j+1:]
+	}
+
+	var threads uint64
+	for _, b := range fields {
+		if b < '0' || b > '9' {
+			break
+		}
+		threads = threads*10 + uint64(b-'0')
+	}
+
+	return state, threads, nil
+}
+
+func getProcStateSummary() (*ProcStateSummary, error) {
+	pids, err := getPids()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := ProcStateSummary{States: make(map[string]uint64)}
+	for _, name := range procStateNames {
+		ret.States[name] = 0
+	}
+	ret.States["other"] = 0
+
+	buf := make([]byte, 4096)
+
+	for _, pid := range pids {
+		// The process may have exited since the listing
+		state, threads, err := readProcState(pid, buf)
+		if err != nil {
+			continue
+		}
+
+		ret.Total++
+		ret.Threads += threads
+
+		if name, ok := procStateNames[string(state)]; ok {
+			ret.States[name]++
+		} else {
+			ret.States["other"]++
+		}
+	}
+
+	return &ret, nil
+}
+
+type ProcStateCollector struct {
+	host     string
+	Interval int64
+}
+
+func (pc *ProcStateCollector) Config(config map[string]interface{}) {
+	fmt.Printf("%s config %#v\n", pc.Name(), pc)
+
+	pc.host, _ = os.Hostname()
+
+	if err := mapstructure.Decode(config, pc); err != nil {
+		panic(err)
+	}
+
+	if pc.Interval == 0 {
+		pc.Interval = 1
+	}
+
+	fmt.Printf("%s config %#v\n", pc.Name(), pc)
+}
+
+func (pc *ProcStateCollector) Detect() bool {
+	if runtime.GOOS == "linux" {
+		return true
+	} else {
+		return false
+	}
+}
+
+func (pc *ProcStateCollector) Run(c chan *Metric) {
+
+	if !pc.Detect() {
+		return
+	}
+
+	var pforks uint64
+	if stats, err := getCpuStats(); err == nil {
+		pforks = stats.Processes
+	}
+
+	for {
+		time.Sleep(time.Duration(pc.Interval) * time.Second)
+
+		summary, err := getProcStateSummary()
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			c <- Gauge(pc.host + ".procs.total").Record(summary.Total)
+			c <- Gauge(pc.host + ".procs.threads").Record(summary.Threads)
+
+			for name, count := range summary.States {
+				c <- Gauge(pc.host + ".procs." + name).Record(count)
+			}
+		}
+
+		stats, err := getCpuStats()
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		if forks, ok := counterDelta(stats.Processes, pforks); ok {
+			c <- Gauge(pc.host + ".procs.forks").Record(forks / uint64(pc.Interval))
+		}
+		pforks = stats.Processes
+	}
+}
+
+func (*ProcStateCollector) Name() string {
+	return "linux.procstate.stats"
+}
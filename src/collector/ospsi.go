@@ -0,0 +1,174 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/Searchlight/khronus-go-client"
+	"github.com/mitchellh/mapstructure"
+)
+
+// Resources with a pressure file in /proc/pressure.
+var pressureResources = []string{"cpu", "memory", "io"}
+
+type PressureStat struct {
+	Avg10  float64 // Percentage of time stalled over the last 10 seconds
+	Avg60  float64 // Percentage of time stalled over the last 60 seconds
+	Avg300 float64 // Percentage of time stalled over the last 300 seconds
+	Total  uint64  // Total stall time in microseconds
+}
+
+type Pressure struct {
+	Some PressureStat // Some tasks stalled on the resource
+	Full PressureStat // All non idle tasks stalled on the resource
+}
+
+func parsePressure(stat *PressureStat, fields []string) error {
+	var err error
+
+	for _, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "avg10":
+			if stat.Avg10, err = strconv.ParseFloat(kv[1], 64); err != nil {
+				return err
+			}
+		case "avg60":
+			if stat.Avg60, err = strconv.ParseFloat(kv[1], 64); err != nil {
+				return err
+			}
+		case "avg300":
+			if stat.Avg300, err = strconv.ParseFloat(kv[1], 64); err != nil {
+				return err
+			}
+		case "total":
+			if stat.Total, err = strconv.ParseUint(kv[1], 10, 64); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func getPressure(resource string) (*Pressure, error) {
+	pressureStats := procPath("pressure", resource)
+	ret := Pressure{}
+
+	contents, err := ioutil.ReadFile(pressureStats)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(bytes.NewBuffer(contents))
+	for {
+		line, _, err := reader.ReadLine()
+		if err == io.EOF {
+			break
+		}
+
+		fields := strings.Fields(string(line))
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "some":
+			if err := parsePressure(&ret.Some, fields[1:]); err != nil {
+				return nil, err
+			}
+		case "full":
+			if err := parsePressure(&ret.Full, fields[1:]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &ret, nil
+}
+
+type PsiCollector struct {
+	host     string
+	Interval int64
+}
+
+func (pc *PsiCollector) Config(config map[string]interface{}) {
+	fmt.Printf("%s config %#v\n", pc.Name(), pc)
+
+	pc.host, _ = os.Hostname()
+
+	if err := mapstructure.Decode(config, pc); err != nil {
+		panic(err)
+	}
+
+	if pc.Interval == 0 {
+		pc.Interval = 1
+	}
+
+	fmt.Printf("%s config %#v\n", pc.Name(), pc)
+}
+
+// Detect reports whether the kernel exposes pressure stall information,
+// available since 4.20 and only when psi is not disabled at boot.
+func (pc *PsiCollector) Detect() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+
+	_, err := getPressure("cpu")
+	return err == nil
+}
+
+func (pc *PsiCollector) emit(c chan *Metric, prefix string, stat PressureStat, pstat PressureStat) {
+	c <- Gauge(prefix + ".avg10").Record(uint64(stat.Avg10 * 100))
+	c <- Gauge(prefix + ".avg60").Record(uint64(stat.Avg60 * 100))
+	c <- Gauge(prefix + ".avg300").Record(uint64(stat.Avg300 * 100))
+
+	if stat.Total >= pstat.Total {
+		c <- Gauge(prefix + ".total").Record((stat.Total - pstat.Total) / uint64(pc.Interval))
+	}
+}
+
+func (pc *PsiCollector) Run(c chan *Metric) {
+
+	if !pc.Detect() {
+		return
+	}
+
+	previous := make(map[string]*Pressure)
+
+	for {
+		for _, resource := range pressureResources {
+			pressure, err := getPressure(resource)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+
+			if ppressure, ok := previous[resource]; ok {
+				pc.emit(c, pc.host+".pressure."+resource+".some", pressure.Some, ppressure.Some)
+				pc.emit(c, pc.host+".pressure."+resource+".full", pressure.Full, ppressure.Full)
+			}
+
+			previous[resource] = pressure
+		}
+
+		time.Sleep(time.Duration(pc.Interval) * time.Second)
+	}
+}
+
+func (*PsiCollector) Name() string {
+	return "linux.psi.stats"
+}
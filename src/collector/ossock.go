@@ -0,0 +1,170 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/Searchlight/khronus-go-client"
+	"github.com/mitchellh/mapstructure"
+)
+
+// Names of the tcp states, indexed by the hexadecimal st column of
+// /proc/net/tcp.
+var tcpStates = []string{
+	"", "established", "syn_sent", "syn_recv", "fin_wait1", "fin_wait2", "time_wait",
+	"close", "close_wait", "last_ack", "listen", "closing", "new_syn_recv",
+}
+
+// parseSockstat parses lines as "TCP: inuse 5 orphan 0 tw 2 alloc 7 mem 1",
+// keyed as "tcp.inuse". The mem values are converted from pages to bytes.
+func parseSockstat(path string, ret map[string]uint64) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(bytes.NewBuffer(contents))
+	for {
+		line, _, err := reader.ReadLine()
+		if err == io.EOF {
+			break
+		}
+
+		fields := strings.Fields(string(line))
+		if len(fields) < 3 {
+			continue
+		}
+
+		proto := strings.ToLower(strings.TrimSuffix(fields[0], ":"))
+		for k := 1; k+1 < len(fields); k += 2 {
+			value, err := strconv.ParseUint(fields[k+1], 10, 64)
+			if err != nil {
+				return err
+			}
+
+			if fields[k] == "mem" {
+				value *= uint64(os.Getpagesize())
+			}
+			ret[proto+"."+fields[k]] = value
+		}
+	}
+
+	return nil
+}
+
+// countTcpStates adds the sockets of a /proc/net/tcp or tcp6 table to the
+// count of their state.
+func countTcpStates(path string, ret []uint64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	// Skip the header
+	scanner.Scan()
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+
+		state, err := strconv.ParseUint(fields[3], 16, 8)
+		if err != nil || state >= uint64(len(ret)) {
+			continue
+		}
+		ret[state]++
+	}
+
+	return scanner.Err()
+}
+
+type SockCollector struct {
+	host     string
+	Interval int64
+}
+
+func (sc *SockCollector) Config(config map[string]interface{}) {
+	fmt.Printf("%s config %#v\n", sc.Name(), sc)
+
+	sc.host, _ = os.Hostname()
+
+	if err := mapstructure.Decode(config, sc); err != nil {
+		panic(err)
+	}
+
+	if sc.Interval == 0 {
+		sc.Interval = 1
+	}
+
+	fmt.Printf("%s config %#v\n", sc.Name(), sc)
+}
+
+func (sc *SockCollector) Detect() bool {
+	if runtime.GOOS == "linux" {
+		return true
+	} else {
+		return false
+	}
+}
+
+func (sc *SockCollector) Run(c chan *Metric) {
+
+	if !sc.Detect() {
+		return
+	}
+
+	for {
+		sockstat := make(map[string]uint64)
+
+		if err := parseSockstat(procPath("net", "sockstat"), sockstat); err != nil {
+			fmt.Println(err)
+		}
+
+		if err := parseSockstat(procPath("net", "sockstat6"), sockstat); err != nil && !os.IsNotExist(err) {
+			fmt.Println(err)
+		}
+
+		for k, v := range sockstat {
+			c <- Gauge(sc.host + ".sock." + k).Record(v)
+		}
+
+		states := make([]uint64, len(tcpStates))
+
+		for _, table := range []string{"tcp", "tcp6"} {
+			if err := countTcpStates(procPath("net", table), states); err != nil && !os.IsNotExist(err) {
+				fmt.Println(err)
+			}
+		}
+
+		for k, v := range states[1:] {
+			c <- Gauge(sc.host + ".sock.tcp.state." + tcpStates[k+1]).Record(v)
+		}
+
+		// The conntrack files only exist once nf_conntrack is loaded
+		count, err := readUint(procPath("sys", "net", "netfilter", "nf_conntrack_count"))
+		if err == nil {
+			max, _ := readUint(procPath("sys", "net", "netfilter", "nf_conntrack_max"))
+
+			c <- Gauge(sc.host + ".conntrack.count").Record(count)
+			c <- Gauge(sc.host + ".conntrack.max").Record(max)
+			c <- Gauge(sc.host + ".conntrack.used_percent").Record(percent(count, max))
+		}
+
+		time.Sleep(time.Duration(sc.Interval) * time.Second)
+	}
+}
+
+func (*SockCollector) Name() string {
+	return "linux.sock.stats"
+}
@@ -0,0 +1,167 @@
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/Searchlight/khronus-go-client"
+	"github.com/mitchellh/mapstructure"
+)
+
+type CoreThermal struct {
+	Cpu              string // Core number
+	CurFreq          uint64 // Current frequency in kHz
+	CoreThrottles    uint64 // Times the core was throttled since boot
+	PackageThrottles uint64 // Times the package of the core was throttled since boot
+	Throttling       bool   // Whether the core exposes throttle counters
+}
+
+type ThermalZone struct {
+	Zone string // Zone number
+	Type string // Sensor name, x86_pkg_temp or acpitz
+	Temp int64  // Temperature in millidegrees Celsius, negative below zero
+}
+
+// getCoreThermals reads the frequency and throttle counters of every core,
+// the files missing on a host are left at zero.
+func getCoreThermals() ([]CoreThermal, error) {
+	dirs, err := filepath.Glob(sysPath("devices", "system", "cpu", "cpu[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []CoreThermal
+
+	for _, dir := range dirs {
+		core := CoreThermal{Cpu: strings.TrimPrefix(filepath.Base(dir), "cpu")}
+
+		core.CurFreq, _ = readUint(filepath.Join(dir, "cpufreq", "scaling_cur_freq"))
+		core.CoreThrottles, err = readUint(filepath.Join(dir, "thermal_throttle", "core_throttle_count"))
+		core.Throttling = err == nil
+		core.PackageThrottles, _ = readUint(filepath.Join(dir, "thermal_throttle", "package_throttle_count"))
+
+		ret = append(ret, core)
+	}
+
+	return ret, nil
+}
+
+func getThermalZones() ([]ThermalZone, error) {
+	dirs, err := filepath.Glob(sysPath("class", "thermal", "thermal_zone[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []ThermalZone
+
+	for _, dir := range dirs {
+		zone := ThermalZone{Zone: strings.TrimPrefix(filepath.Base(dir), "thermal_zone")}
+
+		// Reading a sensor may fail while the device is suspended
+		temp, err := readString(filepath.Join(dir, "temp"))
+		if err != nil {
+			continue
+		}
+		if zone.Temp, err = strconv.ParseInt(temp, 10, 64); err != nil {
+			continue
+		}
+		zone.Type, _ = readString(filepath.Join(dir, "type"))
+
+		ret = append(ret, zone)
+	}
+
+	return ret, nil
+}
+
+type ThermalCollector struct {
+	host     string
+	Interval int64
+}
+
+func (tc *ThermalCollector) Config(config map[string]interface{}) {
+	fmt.Printf("%s config %#v\n", tc.Name(), tc)
+
+	tc.host, _ = os.Hostname()
+
+	if err := mapstructure.Decode(config, tc); err != nil {
+		panic(err)
+	}
+
+	if tc.Interval == 0 {
+		tc.Interval = 1
+	}
+
+	fmt.Printf("%s config %#v\n", tc.Name(), tc)
+}
+
+func (tc *ThermalCollector) Detect() bool {
+	if runtime.GOOS == "linux" {
+		return true
+	} else {
+		return false
+	}
+}
+
+func (tc *ThermalCollector) Run(c chan *Metric) {
+
+	if !tc.Detect() {
+		return
+	}
+
+	previous := make(map[string]CoreThermal)
+
+	for {
+		cores, err := getCoreThermals()
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		for _, core := range cores {
+			prefix := tc.host + ".cpu." + core.Cpu
+
+			if core.CurFreq > 0 {
+				c <- Gauge(prefix + ".freq_mhz").Record(core.CurFreq / 1000)
+			}
+
+			if pcore, ok := previous[core.Cpu]; ok && core.Throttling {
+				if core.CoreThrottles >= pcore.CoreThrottles {
+					c <- Gauge(prefix + ".throttle.core").Record(core.CoreThrottles - pcore.CoreThrottles)
+				}
+				if core.PackageThrottles >= pcore.PackageThrottles {
+					c <- Gauge(prefix + ".throttle.package").Record(core.PackageThrottles - pcore.PackageThrottles)
+				}
+			}
+
+			previous[core.Cpu] = core
+		}
+
+		zones, err := getThermalZones()
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		for _, zone := range zones {
+			prefix := tc.host + ".thermal.zone" + zone.Zone + "." + metricPart(zone.Type)
+
+			// Gauges are unsigned, a sub-zero sensor reports 0 and how far
+			// below zero it is
+			if zone.Temp < 0 {
+				c <- Gauge(prefix).Record(0)
+				c <- Gauge(prefix + ".below_zero").Record(uint64(-zone.Temp / 1000))
+			} else {
+				c <- Gauge(prefix).Record(uint64(zone.Temp / 1000))
+			}
+		}
+
+		time.Sleep(time.Duration(tc.Interval) * time.Second)
+	}
+}
+
+func (*ThermalCollector) Name() string {
+	return "linux.thermal.stats"
+}
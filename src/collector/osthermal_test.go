@@ -0,0 +1,88 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFixture creates the files of a sysfs fixture tree under root.
+func writeFixture(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func withSysRoot(t *testing.T, root string) {
+	previous := sysRoot
+	sysRoot = root
+	t.Cleanup(func() { sysRoot = previous })
+}
+
+func TestGetCoreThermals(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{
+		"devices/system/cpu/cpu0/cpufreq/scaling_cur_freq":                "2400000",
+		"devices/system/cpu/cpu0/thermal_throttle/core_throttle_count":    "12",
+		"devices/system/cpu/cpu0/thermal_throttle/package_throttle_count": "3",
+		"devices/system/cpu/cpu1/cpufreq/scaling_cur_freq":                "800000",
+		"devices/system/cpu/cpufreq/boost":                                "1",
+	})
+	withSysRoot(t, root)
+
+	cores, err := getCoreThermals()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []CoreThermal{
+		{Cpu: "0", CurFreq: 2400000, CoreThrottles: 12, PackageThrottles: 3, Throttling: true},
+		{Cpu: "1", CurFreq: 800000},
+	}
+
+	if len(cores) != len(expected) {
+		t.Fatalf("got %d cores, expected %d: %+v", len(cores), len(expected), cores)
+	}
+	for i := range expected {
+		if cores[i] != expected[i] {
+			t.Errorf("core %d is %+v, expected %+v", i, cores[i], expected[i])
+		}
+	}
+}
+
+func TestGetThermalZones(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{
+		"class/thermal/thermal_zone0/temp": "45000",
+		"class/thermal/thermal_zone0/type": "x86_pkg_temp",
+		"class/thermal/thermal_zone1/temp": "-5500",
+		"class/thermal/thermal_zone1/type": "acpitz",
+		"class/thermal/thermal_zone2/type": "suspended",
+	})
+	withSysRoot(t, root)
+
+	zones, err := getThermalZones()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ThermalZone{
+		{Zone: "0", Type: "x86_pkg_temp", Temp: 45000},
+		{Zone: "1", Type: "acpitz", Temp: -5500},
+	}
+
+	if len(zones) != len(expected) {
+		t.Fatalf("got %d zones, expected %d: %+v", len(zones), len(expected), zones)
+	}
+	for i := range expected {
+		if zones[i] != expected[i] {
+			t.Errorf("zone %d is %+v, expected %+v", i, zones[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/Searchlight/khronus-go-client"
+	"github.com/mitchellh/mapstructure"
+)
+
+// Paging, swapping, reclaim, oom and transparent hugepage counters emitted
+// when no Keys are configured.
+var defaultVmstatKeys = []string{
+	"pgpgin", "pgpgout",
+	"pswpin", "pswpout",
+	"pgfault", "pgmajfault",
+	"pgscan_direct", "pgscan_kswapd",
+	"pgsteal_direct", "pgsteal_kswapd",
+	"oom_kill",
+	"thp_fault_alloc", "thp_fault_fallback",
+	"thp_collapse_alloc", "thp_collapse_alloc_failed",
+	"thp_split_page",
+}
+
+func getVmstat() (map[string]uint64, error) {
+	vmStats := procPath("vmstat")
+	ret := make(map[string]uint64)
+
+	contents, err := ioutil.ReadFile(vmStats)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(bytes.NewBuffer(contents))
+	for {
+		line, _, err := reader.ReadLine()
+		if err == io.EOF {
+			break
+		}
+
+		fields := strings.Fields(string(line))
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ret[fields[0]] = value
+	}
+
+	return ret, nil
+}
+
+type VmstatCollector struct {
+	host     string
+	Interval int64
+	Keys     []string // /proc/vmstat counters to emit
+}
+
+func (vc *VmstatCollector) Config(config map[string]interface{}) {
+	fmt.Printf("%s config %#v\n", vc.Name(), vc)
+
+	vc.host, _ = os.Hostname()
+
+	if err := mapstructure.Decode(config, vc); err != nil {
+		panic(err)
+	}
+
+	if vc.Interval == 0 {
+		vc.Interval = 1
+	}
+
+	if len(vc.Keys) == 0 {
+		vc.Keys = defaultVmstatKeys
+	}
+
+	fmt.Printf("%s config %#v\n", vc.Name(), vc)
+}
+
+func (vc *VmstatCollector) Detect() bool {
+	if runtime.GOOS == "linux" {
+		return true
+	} else {
+		return false
+	}
+}
+
+func (vc *VmstatCollector) Run(c chan *Metric) {
+
+	if !vc.Detect() {
+		return
+	}
+
+	pvmstat, err := getVmstat()
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	for {
+		time.Sleep(time.Duration(vc.Interval) * time.Second)
+
+		vmstat, err := getVmstat()
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+
+		for _, key := range vc.Keys {
+			value, ok := vmstat[key]
+			if !ok {
+				continue
+			}
+
+			// Keys missing from the previous read or reset since are skipped
+			pvalue, ok := pvmstat[key]
+			if !ok || value < pvalue {
+				continue
+			}
+
+			c <- Gauge(vc.host + ".vmstat." + key).Record((value - pvalue) / uint64(vc.Interval))
+		}
+
+		pvmstat = vmstat
+	}
+}
+
+func (*VmstatCollector) Name() string {
+	return "linux.vmstat.stats"
+}
@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Mount points of procfs and sysfs, configurable to read the host
+// filesystems from inside a container or a fixture tree.
+var (
+	procRoot = "/proc"
+	sysRoot  = "/sys"
+)
+
+func procPath(elem ...string) string {
+	return filepath.Join(append([]string{procRoot}, elem...)...)
+}
+
+func sysPath(elem ...string) string {
+	return filepath.Join(append([]string{sysRoot}, elem...)...)
+}
+
+// readString returns the trimmed content of a single value file.
+func readString(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(contents)), nil
+}
+
+// readUint returns the content of a single value file holding an integer.
+func readUint(path string) (uint64, error) {
+	value, err := readString(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// readUints reads a file holding integers separated by whitespace.
+func readUints(path string, n int) ([]uint64, error) {
+	value, err := readString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) < n {
+		return nil, fmt.Errorf("%s doesn't have the expected format. Expected %d fields found %d", path, n, len(fields))
+	}
+
+	ret := make([]uint64, n)
+	for i := range ret {
+		if ret[i], err = strconv.ParseUint(fields[i], 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,106 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"sort"
+)
+
+// Points every upstream owns on the hash ring, more points spread the keys
+// more evenly.
+const relayReplicas = 160
+
+// statsdRelay forwards the received statsd lines to upstream statsd servers.
+// Lines are spread over the upstreams with a consistent hash of their key, so
+// a key always reaches the same upstream and adding or removing one only
+// moves the keys it owns.
+type statsdRelay struct {
+	points  []uint32
+	owners  map[uint32]int
+	conns   []net.Conn
+	maxSize int
+}
+
+func newStatsdRelay(upstreams []string, maxSize int) (*statsdRelay, error) {
+	r := &statsdRelay{
+		owners:  make(map[uint32]int),
+		maxSize: maxSize,
+	}
+
+	for k, upstream := range upstreams {
+		conn, err := net.Dial("udp", upstream)
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		r.conns = append(r.conns, conn)
+
+		for i := 0; i < relayReplicas; i++ {
+			point := crc32.ChecksumIEEE([]byte(fmt.Sprintf("%s-%d", upstream, i)))
+			if _, ok := r.owners[point]; ok {
+				continue
+			}
+			r.owners[point] = k
+			r.points = append(r.points, point)
+		}
+	}
+
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+
+	return r, nil
+}
+
+// upstream returns the index of the upstream owning key.
+func (r *statsdRelay) upstream(key []byte) int {
+	h := crc32.ChecksumIEEE(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// forward sends every line of packet to the upstream owning its key, batching
+// the lines of each upstream in datagrams of at most maxSize bytes. Delivery
+// is best effort, as with any udp statsd client.
+func (r *statsdRelay) forward(packet []byte) {
+	batches := make([][]byte, len(r.conns))
+
+	for len(packet) > 0 {
+		line := packet
+		if i := bytes.IndexByte(packet, '\n'); i >= 0 {
+			line, packet = packet[:i], packet[i+1:]
+		} else {
+			packet = nil
+		}
+
+		i := bytes.IndexByte(line, ':')
+		if i <= 0 {
+			continue
+		}
+
+		u := r.upstream(line[:i])
+		if len(batches[u]) > 0 && len(batches[u])+len(line)+1 > r.maxSize {
+			r.conns[u].Write(batches[u])
+			batches[u] = batches[u][:0]
+		}
+		if len(batches[u]) > 0 {
+			batches[u] = append(batches[u], '\n')
+		}
+		batches[u] = append(batches[u], line...)
+	}
+
+	for u, batch := range batches {
+		if len(batch) > 0 {
+			r.conns[u].Write(batch)
+		}
+	}
+}
+
+func (r *statsdRelay) close() {
+	for _, conn := range r.conns {
+		conn.Close()
+	}
+}
@@ -1,29 +1,55 @@
 package collector
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"math"
 	"net"
-	"regexp"
+	"os"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	. "github.com/Searchlight/khronus-go-client"
 	"github.com/mitchellh/mapstructure"
 )
 
 type StatsdCollector struct {
-	Interval      int64
-	Port          float64
-	CounterPrefix string
-	GaugesPrefix  string
-	TimersPrefix  string
+	Interval       int64
+	Port           float64
+	TcpPort        float64 // Newline delimited tcp listener, disabled when 0
+	UnixgramSocket string  // Path of a unix datagram socket, disabled when empty
+	UnixSocket     string  // Path of a newline delimited unix stream socket, disabled when empty
+	SocketMode     string  // Octal permissions applied to the unix sockets
+	CounterPrefix  string
+	GaugesPrefix   string
+	TimersPrefix   string
+	MaxPacketSize  int      // Larger datagrams are truncated, longer lines close the connection
+	Workers        int      // Goroutines parsing the received datagrams
+	ReadBuffer     int      // SO_RCVBUF of the datagram sockets, system default when 0
+	Namespaces     []string // Accepted key namespaces, every key is accepted when empty
+	MaxKeys        int      // Distinct keys accepted per interval, unlimited when 0
+	Relay          []string // Upstream statsd servers receiving a copy of every line
+
+	host     string
+	relay    *statsdRelay
+	packets  chan *[]byte
+	buffers  sync.Pool
+	mutex    sync.Mutex // Guards keys
+	keys     map[string]struct{}
+	rejected atomic.Uint64
 }
 
 func (stdc *StatsdCollector) Config(config map[string]interface{}) {
 	fmt.Printf("%s config %#v\n", stdc.Name(), stdc)
 
+	stdc.host, _ = os.Hostname()
+
 	if err := mapstructure.Decode(config, stdc); err != nil {
 		panic(err)
 	}
@@ -32,6 +58,32 @@ func (stdc *StatsdCollector) Config(config map[string]interface{}) {
 		stdc.Port = 8125
 	}
 
+	if stdc.SocketMode == "" {
+		stdc.SocketMode = "0666"
+	}
+
+	if stdc.MaxPacketSize == 0 {
+		stdc.MaxPacketSize = 8192
+	}
+
+	if stdc.Workers == 0 {
+		stdc.Workers = runtime.NumCPU()
+	}
+
+	if stdc.Interval == 0 {
+		stdc.Interval = 1
+	}
+
+	for k, v := range stdc.Namespaces {
+		stdc.Namespaces[k] = strings.TrimSuffix(v, ".")
+	}
+
+	stdc.CounterPrefix = strings.TrimSuffix(stdc.CounterPrefix, ".")
+	stdc.GaugesPrefix = strings.TrimSuffix(stdc.GaugesPrefix, ".")
+	stdc.TimersPrefix = strings.TrimSuffix(stdc.TimersPrefix, ".")
+
+	stdc.keys = make(map[string]struct{})
+
 	fmt.Printf("%s config %#v\n", stdc.Name(), stdc)
 }
 
@@ -39,80 +91,430 @@ func (stdc *StatsdCollector) Detect() bool {
 	return true
 }
 
-func handleMessage(buf *bytes.Buffer, c chan *Metric) {
-	var sanitizeRegexp = regexp.MustCompile("[^a-zA-Z0-9\\-_\\.:\\|@]")
-	var packetRegexp = regexp.MustCompile("([a-zA-Z0-9\\-_\\.]+)((:[0-9]+\\|(c|g|ms)(\\|@([0-9\\.]+))?)+)")
-	s := sanitizeRegexp.ReplaceAllString(buf.String(), "")
+// statsdNameByte reports whether b may appear in a bucket name. Any other
+// byte is dropped from the name.
+func statsdNameByte(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z') || (b >= '0' && b <= '9') ||
+		b == '-' || b == '_' || b == '.'
+}
+
+func sanitizeName(name []byte) string {
+	for i := 0; i < len(name); i++ {
+		if statsdNameByte(name[i]) {
+			continue
+		}
+
+		clean := make([]byte, 0, len(name))
+		clean = append(clean, name[:i]...)
+		for _, b := range name[i:] {
+			if statsdNameByte(b) {
+				clean = append(clean, b)
+			}
+		}
+		return string(clean)
+	}
+	return string(name)
+}
+
+// parseSample parses a single "value|type[|@rate]" sample. Counter values are
+// scaled up by their sampling rate.
+func parseSample(sample []byte) (uint64, string, bool) {
+	i := bytes.IndexByte(sample, '|')
+	if i < 0 {
+		return 0, "", false
+	}
+
+	value, err := strconv.ParseFloat(string(bytes.TrimSpace(sample[:i])), 64)
+	if err != nil || value < 0 || math.IsInf(value, 0) {
+		return 0, "", false
+	}
 
-	for _, item := range packetRegexp.FindAllStringSubmatch(s, -1) {
-		values := []uint64{}
+	kind, rate := sample[i+1:], []byte(nil)
+	if j := bytes.IndexByte(kind, '|'); j >= 0 {
+		kind, rate = kind[:j], kind[j+1:]
+	}
+
+	var t string
+	switch string(bytes.TrimSpace(kind)) {
+	case "c":
+		t = "c"
+	case "g":
+		t = "g"
+	case "ms":
+		t = "ms"
+	default:
+		return 0, "", false
+	}
+
+	if t == "c" && len(rate) > 1 && rate[0] == '@' {
+		if r, err := strconv.ParseFloat(string(bytes.TrimSpace(rate[1:])), 64); err == nil && r > 0 && r < 1 {
+			value = value / r
+		}
+	}
 
-		for _, v := range strings.Split(item[2], ":")[1:] {
-			sp := strings.Split(v, "|")
-			value, _ := strconv.ParseUint(sp[0], 10, 64)
+	return uint64(value + 0.5), t, true
+}
 
-			if len(sp) == 3 {
-				s, _ := strconv.ParseFloat(sp[2][1:], 64)
-				num := int(math.Mod(float64(value), 1/float64(s)))
-				value := uint(float64(value) * s)
+// accept reports whether name is inside one of the configured namespaces
+// and, when MaxKeys is set, either already seen or still under the limit
+// for the current interval. Only the MaxKeys check serialises the workers.
+func (stdc *StatsdCollector) accept(name string) bool {
+	if len(stdc.Namespaces) == 0 && stdc.MaxKeys == 0 {
+		return true
+	}
 
-				for i := 0; i < num; i++ {
-					values = append(values, uint64(value))
-				}
-			} else {
-				values = append(values, uint64(value))
+	if len(stdc.Namespaces) > 0 {
+		allowed := false
+		for _, ns := range stdc.Namespaces {
+			if name == ns || strings.HasPrefix(name, ns+".") {
+				allowed = true
+				break
 			}
 		}
 
-		switch item[4] {
-		case "c":
-			c <- Counter(item[1]).Record(values...)
-		case "g":
-			c <- Gauge(item[1]).Record(values...)
-		case "ms":
-			c <- Timer(item[1]).Record(values...)
+		if !allowed {
+			stdc.rejected.Add(1)
+			return false
 		}
+	}
 
-		/*
-			fmt.Println(
-				fmt.Sprintf("Packet: bucket = %s, value = %+v, modifier = %s, sampling = %f\n",
-					item[1], values, item[4], sampleRate))
-		*/
+	if stdc.MaxKeys > 0 {
+		stdc.mutex.Lock()
+		defer stdc.mutex.Unlock()
+
+		if _, ok := stdc.keys[name]; !ok {
+			if len(stdc.keys) >= stdc.MaxKeys {
+				stdc.rejected.Add(1)
+				return false
+			}
+			stdc.keys[name] = struct{}{}
+		}
 	}
+
+	return true
 }
 
-func (stdc *StatsdCollector) Run(c chan *Metric) {
+// rotateKeys forgets the keys seen in the last interval and returns how many
+// samples were rejected during it.
+func (stdc *StatsdCollector) rotateKeys() uint64 {
+	stdc.mutex.Lock()
+	stdc.keys = make(map[string]struct{})
+	stdc.mutex.Unlock()
 
-	if !stdc.Detect() {
+	return stdc.rejected.Swap(0)
+}
+
+// resetKeys rotates the keys every interval and reports the rejected samples.
+func (stdc *StatsdCollector) resetKeys(c chan *Metric) {
+	for {
+		time.Sleep(time.Duration(stdc.Interval) * time.Second)
+
+		if rejected := stdc.rotateKeys(); rejected > 0 {
+			fmt.Printf("%s rejected %d samples\n", stdc.Name(), rejected)
+			c <- Counter(stdc.host + ".statsd.rejected").Record(rejected)
+		}
+	}
+}
+
+// prefixed joins a configured prefix, if any, to a key.
+func prefixed(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// newStatsdMetric builds the metric of a statsd type, tests replace it to see
+// the names and values recorded.
+var newStatsdMetric = func(kind string, name string, values ...uint64) *Metric {
+	switch kind {
+	case "c":
+		return Counter(name).Record(values...)
+	case "g":
+		return Gauge(name).Record(values...)
+	default:
+		return Timer(name).Record(values...)
+	}
+}
+
+func (stdc *StatsdCollector) record(name string, kind string, values []uint64, c chan *Metric) {
+	if len(values) == 0 || !stdc.accept(name) {
 		return
 	}
 
-	address, err := net.ResolveUDPAddr("udp", ":8125")
+	switch kind {
+	case "c":
+		c <- newStatsdMetric(kind, prefixed(stdc.CounterPrefix, name), values...)
+	case "g":
+		c <- newStatsdMetric(kind, prefixed(stdc.GaugesPrefix, name), values...)
+	case "ms":
+		c <- newStatsdMetric(kind, prefixed(stdc.TimersPrefix, name), values...)
+	}
+}
 
-	if err != nil {
-		fmt.Println(err)
+// handleLine parses a "bucket:value|type[|@rate][:value|type...]" line.
+func (stdc *StatsdCollector) handleLine(line []byte, c chan *Metric) {
+	i := bytes.IndexByte(line, ':')
+	if i < 0 {
 		return
 	}
 
-	listener, err := net.ListenUDP("udp", address)
-	defer listener.Close()
+	name := sanitizeName(line[:i])
+	if name == "" {
+		return
+	}
 
+	kind := ""
+	values := []uint64{}
+
+	for rest := line[i+1:]; len(rest) > 0; {
+		sample := rest
+		if j := bytes.IndexByte(rest, ':'); j >= 0 {
+			sample, rest = rest[:j], rest[j+1:]
+		} else {
+			rest = nil
+		}
+
+		value, t, ok := parseSample(sample)
+		if !ok {
+			continue
+		}
+
+		if t != kind {
+			stdc.record(name, kind, values, c)
+			kind, values = t, []uint64{}
+		}
+		values = append(values, value)
+	}
+
+	stdc.record(name, kind, values, c)
+}
+
+// handleMessage parses every newline separated line of a statsd packet.
+func (stdc *StatsdCollector) handleMessage(buf []byte, c chan *Metric) {
+	if stdc.relay != nil {
+		stdc.relay.forward(buf)
+	}
+
+	for len(buf) > 0 {
+		line := buf
+		if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+			line, buf = buf[:i], buf[i+1:]
+		} else {
+			buf = nil
+		}
+
+		stdc.handleLine(line, c)
+	}
+}
+
+// removeSocket deletes a stale socket file left at path by a previous run.
+// Anything else found at path is left alone, a mistyped path must not delete
+// a regular file.
+func removeSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	return os.Remove(path)
+}
+
+// chmodSocket applies the configured SocketMode to the socket at path.
+func (stdc *StatsdCollector) chmodSocket(path string) error {
+	mode, err := strconv.ParseUint(stdc.SocketMode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid socket mode %s: %s", stdc.SocketMode, err)
+	}
+
+	return os.Chmod(path, os.FileMode(mode))
+}
+
+func (stdc *StatsdCollector) listenUnix(path string) (net.Listener, error) {
+	if err := removeSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stdc.chmodSocket(path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+func (stdc *StatsdCollector) listenUnixgram(path string) (net.PacketConn, error) {
+	if err := removeSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stdc.chmodSocket(path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// servePacket queues every datagram received on listener to the workers.
+func (stdc *StatsdCollector) servePacket(listener net.PacketConn) {
+	defer listener.Close()
+
+	if stdc.ReadBuffer > 0 {
+		if conn, ok := listener.(interface {
+			SetReadBuffer(bytes int) error
+		}); ok {
+			if err := conn.SetReadBuffer(stdc.ReadBuffer); err != nil {
+				fmt.Println(err)
+			}
+		}
 	}
 
 	for {
-		message := make([]byte, 1024)
-		n, _, error := listener.ReadFrom(message)
+		message := stdc.buffers.Get().(*[]byte)
+		n, _, error := listener.ReadFrom((*message)[:cap(*message)])
+		if errors.Is(error, net.ErrClosed) {
+			return
+		}
 		if error != nil {
+			stdc.buffers.Put(message)
+			continue
+		}
+		*message = (*message)[:n]
+		stdc.packets <- message
+	}
+}
+
+// work parses the queued datagrams and hands their buffers back to the pool.
+func (stdc *StatsdCollector) work(c chan *Metric) {
+	for message := range stdc.packets {
+		stdc.handleMessage(*message, c)
+		stdc.buffers.Put(message)
+	}
+}
+
+// serveStream accepts connections on listener and handles every line
+// received on them as a statsd packet.
+func (stdc *StatsdCollector) serveStream(listener net.Listener, c chan *Metric) {
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
 			continue
 		}
-		buf := bytes.NewBuffer(message[0:n])
-		go handleMessage(buf, c)
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+
+			scanner := bufio.NewScanner(conn)
+			scanner.Buffer(make([]byte, 4096), stdc.MaxPacketSize)
+			for scanner.Scan() {
+				stdc.handleMessage(scanner.Bytes(), c)
+			}
+
+			if err := scanner.Err(); err != nil {
+				fmt.Println(err)
+			}
+		}(conn)
+	}
+}
+
+// startWorkers sets up the buffer pool and the workers parsing the packets
+// queued by the listeners.
+func (stdc *StatsdCollector) startWorkers(c chan *Metric) {
+	stdc.packets = make(chan *[]byte, stdc.Workers*64)
+	stdc.buffers.New = func() interface{} {
+		message := make([]byte, stdc.MaxPacketSize)
+		return &message
+	}
+
+	for i := 0; i < stdc.Workers; i++ {
+		go stdc.work(c)
 	}
 }
 
+func (stdc *StatsdCollector) Run(c chan *Metric) {
+
+	if !stdc.Detect() {
+		return
+	}
+
+	stdc.startWorkers(c)
+
+	go stdc.resetKeys(c)
+
+	if len(stdc.Relay) > 0 {
+		relay, err := newStatsdRelay(stdc.Relay, stdc.MaxPacketSize)
+
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			stdc.relay = relay
+		}
+	}
+
+	if stdc.TcpPort != 0 {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", int(stdc.TcpPort)))
+
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			go stdc.serveStream(listener, c)
+		}
+	}
+
+	if stdc.UnixSocket != "" {
+		listener, err := stdc.listenUnix(stdc.UnixSocket)
+
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			go stdc.serveStream(listener, c)
+		}
+	}
+
+	if stdc.UnixgramSocket != "" {
+		listener, err := stdc.listenUnixgram(stdc.UnixgramSocket)
+
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			go stdc.servePacket(listener)
+		}
+	}
+
+	listener, err := net.ListenPacket("udp", fmt.Sprintf(":%d", int(stdc.Port)))
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	stdc.servePacket(listener)
+}
+
 func (*StatsdCollector) Name() string {
 	return "statsd"
 }
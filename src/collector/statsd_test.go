@@ -0,0 +1,259 @@
+package collector
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/Searchlight/khronus-go-client"
+)
+
+var statsdPacket = []byte("api.requests:1|c\napi.latency:320|ms|@0.5\napi.sessions:42|g\napi.errors:1|c|@0.1")
+
+func drain(c chan *Metric, done chan struct{}) {
+	for {
+		select {
+		case <-c:
+		case <-done:
+			return
+		}
+	}
+}
+
+// recordStatsd replaces newStatsdMetric for the test, returning the metrics
+// recorded as "kind name values" strings.
+func recordStatsd(t *testing.T) func() []string {
+	var mutex sync.Mutex
+	var recorded []string
+
+	newMetric := newStatsdMetric
+	t.Cleanup(func() { newStatsdMetric = newMetric })
+
+	newStatsdMetric = func(kind string, name string, values ...uint64) *Metric {
+		mutex.Lock()
+		recorded = append(recorded, fmt.Sprintf("%s %s %v", kind, name, values))
+		mutex.Unlock()
+		return &Metric{}
+	}
+
+	return func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		metrics := recorded
+		recorded = nil
+		return metrics
+	}
+}
+
+func TestHandleMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   map[string]interface{}
+		packet   string
+		expected []string
+		rejected uint64
+	}{
+		{
+			name:     "prefixes",
+			config:   map[string]interface{}{"CounterPrefix": "stats.counters.", "GaugesPrefix": "stats.gauges", "TimersPrefix": "stats.timers"},
+			packet:   "api.requests:1|c\napi.sessions:42|g\napi.latency:320|ms",
+			expected: []string{"c stats.counters.api.requests [1]", "g stats.gauges.api.sessions [42]", "ms stats.timers.api.latency [320]"},
+		},
+		{
+			name:     "namespaces",
+			config:   map[string]interface{}{"Namespaces": []string{"api."}},
+			packet:   "api.requests:1|c\nweb.requests:1|c\napi:2|c\napix.requests:1|c",
+			expected: []string{"c api.requests [1]", "c api [2]"},
+			rejected: 2,
+		},
+		{
+			name:     "max keys",
+			config:   map[string]interface{}{"MaxKeys": 1},
+			packet:   "api.requests:1|c\napi.errors:1|c\napi.requests:3|c",
+			expected: []string{"c api.requests [1]", "c api.requests [3]"},
+			rejected: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorded := recordStatsd(t)
+
+			stdc := &StatsdCollector{}
+			stdc.Config(tt.config)
+
+			c := make(chan *Metric, 16)
+			stdc.handleMessage([]byte(tt.packet), c)
+
+			if got := recorded(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("recorded %q, expected %q", got, tt.expected)
+			}
+			if len(c) != len(tt.expected) {
+				t.Errorf("%d metrics sent, expected %d", len(c), len(tt.expected))
+			}
+			if rejected := stdc.rotateKeys(); rejected != tt.rejected {
+				t.Errorf("%d samples rejected, expected %d", rejected, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestRotateKeys(t *testing.T) {
+	recorded := recordStatsd(t)
+
+	stdc := &StatsdCollector{}
+	stdc.Config(map[string]interface{}{"MaxKeys": 1})
+
+	c := make(chan *Metric, 16)
+	stdc.handleMessage([]byte("a:1|c\nb:1|c"), c)
+	if rejected := stdc.rotateKeys(); rejected != 1 {
+		t.Errorf("%d samples rejected, expected 1", rejected)
+	}
+
+	// A new interval accepts a new key
+	recorded()
+	stdc.handleMessage([]byte("b:1|c\na:1|c"), c)
+	if got, expected := recorded(), []string{"c b [1]"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("recorded %q, expected %q", got, expected)
+	}
+	if rejected := stdc.rotateKeys(); rejected != 1 {
+		t.Errorf("%d samples rejected, expected 1", rejected)
+	}
+}
+
+func TestHandleLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		packet   string
+		expected []string
+	}{
+		{"single sample", "a:1|c", []string{"c a [1]"}},
+		{"multiple samples", "a:1|c:2|c:3|c", []string{"c a [1 2 3]"}},
+		{"mixed types", "a:1|c:2|c:5|ms:7|g", []string{"c a [1 2]", "ms a [5]", "g a [7]"}},
+		{"crlf", "a:1|c\r\nb:2|g\r\n", []string{"c a [1]", "g b [2]"}},
+		{"empty lines", "\na:1|c\n\n", []string{"c a [1]"}},
+		{"counter rate", "a:1|c|@0.5", []string{"c a [2]"}},
+		{"counter rate rounding", "a:1|c|@0.3", []string{"c a [3]"}},
+		{"counter full rate", "a:3|c|@1", []string{"c a [3]"}},
+		{"invalid rate", "a:3|c|@x:4|c|@0", []string{"c a [3 4]"}},
+		{"timer rate", "a:320|ms|@0.5", []string{"ms a [320]"}},
+		{"gauge rate", "a:42|g|@0.1", []string{"g a [42]"}},
+		{"invalid type", "a:1|x\nb:1|\nc:1", nil},
+		{"invalid samples skipped", "a:1|x:2|c:abc|c:-1|c:3|c", []string{"c a [2 3]"}},
+		{"empty name", ":1|c\n$#!:1|c", nil},
+		{"sanitized name", "a b/c:1|c", []string{"c abc [1]"}},
+		{"no value", "a\na:\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorded := recordStatsd(t)
+
+			stdc := &StatsdCollector{}
+			stdc.Config(map[string]interface{}{})
+
+			c := make(chan *Metric, 16)
+			stdc.handleMessage([]byte(tt.packet), c)
+
+			if got := recorded(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("recorded %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func BenchmarkHandleMessage(b *testing.B) {
+	stdc := &StatsdCollector{}
+	stdc.Config(map[string]interface{}{})
+
+	c := make(chan *Metric, 1024)
+	done := make(chan struct{})
+	go drain(c, done)
+	defer close(done)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(statsdPacket)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		stdc.handleMessage(statsdPacket, c)
+	}
+
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "packets/s")
+}
+
+func BenchmarkUDPReceive(b *testing.B) {
+	stdc := &StatsdCollector{}
+	stdc.Config(map[string]interface{}{"ReadBuffer": 4 << 20})
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	c := make(chan *Metric, 1024)
+	stdc.startWorkers(c)
+
+	// The workers stop once the listener is closed and drained
+	served := make(chan struct{})
+	go func() {
+		stdc.servePacket(listener)
+		close(served)
+	}()
+	defer func() {
+		listener.Close()
+		<-served
+		close(stdc.packets)
+	}()
+
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	metrics := b.N * 4
+	received := 0
+	sent := make(chan struct{})
+	b.ResetTimer()
+
+	go func() {
+		for i := 0; i < b.N; i++ {
+			conn.Write(statsdPacket)
+		}
+		close(sent)
+	}()
+
+	// Once every packet is sent the timer is stopped and the metrics still
+	// in flight are drained until none arrives for the idle timeout, the
+	// missing ones having been dropped
+	var idle *time.Timer
+	var timeout <-chan time.Time
+
+	for received < metrics {
+		select {
+		case <-c:
+			received++
+			if idle != nil {
+				if !idle.Stop() {
+					<-idle.C
+				}
+				idle.Reset(100 * time.Millisecond)
+			}
+		case <-sent:
+			b.StopTimer()
+			sent = nil
+			idle = time.NewTimer(100 * time.Millisecond)
+			timeout = idle.C
+		case <-timeout:
+			metrics = received
+		}
+	}
+
+	b.StopTimer()
+	b.ReportMetric(float64(received/4)/b.Elapsed().Seconds(), "packets/s")
+	b.ReportMetric(float64(b.N*4-received)*100/float64(b.N*4), "%dropped")
+}
@@ -23,6 +23,8 @@ func main() {
 	app.Action = func(c *cli.Context) {
 		config := make(map[string]interface{})
 		config = map[string]interface{}{
+			"procfs": "/proc",
+			"sysfs":  "/sys",
 			"collectors": map[string]interface{}{
 				"CpuCollector": map[string]interface{}{
 					"Interval": 1,
@@ -39,6 +41,39 @@ func main() {
 				"StatsdCollector": map[string]interface{}{
 					"Interval": 1,
 				},
+				"PsiCollector": map[string]interface{}{
+					"Interval": 1,
+				},
+				"ThermalCollector": map[string]interface{}{
+					"Interval": 1,
+				},
+				"VmstatCollector": map[string]interface{}{
+					"Interval": 1,
+				},
+				"NumaCollector": map[string]interface{}{
+					"Interval": 1,
+				},
+				"FsCollector": map[string]interface{}{
+					"Interval": 1,
+				},
+				"NetstatCollector": map[string]interface{}{
+					"Interval": 1,
+				},
+				"SockCollector": map[string]interface{}{
+					"Interval": 1,
+				},
+				"ProcessCollector": map[string]interface{}{
+					"Interval": 1,
+				},
+				"ProcStateCollector": map[string]interface{}{
+					"Interval": 1,
+				},
+				"CgroupCollector": map[string]interface{}{
+					"Interval": 1,
+				},
+				"LimitsCollector": map[string]interface{}{
+					"Interval": 1,
+				},
 			},
 			"outputs": map[string]interface{}{
 				"KhronusOutput": map[string]interface{}{